@@ -4,7 +4,7 @@ go 1.16
 
 require (
 	github.com/gorilla/websocket v1.4.2
-	github.com/stretchr/testify v1.7.0 // indirect
-	go.uber.org/atomic v1.7.0 // indirect
+	github.com/stretchr/testify v1.7.0
+	go.uber.org/atomic v1.7.0
 	go.uber.org/zap v1.21.0
 )
@@ -0,0 +1,132 @@
+package bar
+
+import (
+	"sync"
+	"time"
+	"vwap-service/internal/vwap"
+)
+
+// DefaultIntervals are the intervals of the bars built when none are provided
+var DefaultIntervals = []time.Duration{time.Second, time.Minute, 5 * time.Minute, time.Hour}
+
+// Bar is an OHLCV bar of the trades of a single trading pair over a time-aligned interval,
+// along with the VWAP, the number of trades and the volume of the buy and sell aggressors
+type Bar struct {
+	Start      time.Time
+	Interval   time.Duration
+	Open       float64
+	High       float64
+	Low        float64
+	Close      float64
+	Volume     float64
+	VWAP       float64
+	Trades     int
+	BuyVolume  float64
+	SellVolume float64
+
+	sumPQ float64
+}
+
+// End returns the end of the interval of the bar, which is excluded from the bar
+func (b Bar) End() time.Time {
+	return b.Start.Add(b.Interval)
+}
+
+// add adds a trade to the bar
+func (b *Bar) add(price float64, volume float64, side vwap.Side) {
+	if b.Trades == 0 {
+		b.Open, b.High, b.Low = price, price, price
+	}
+	if price > b.High {
+		b.High = price
+	}
+	if price < b.Low {
+		b.Low = price
+	}
+	b.Close = price
+	b.Trades++
+
+	b.Volume += volume
+	b.sumPQ += price * volume
+	if b.Volume > 0 {
+		b.VWAP = b.sumPQ / b.Volume
+	}
+
+	switch side {
+	case vwap.SideBuy:
+		b.BuyVolume += volume
+	case vwap.SideSell:
+		b.SellVolume += volume
+	}
+}
+
+// Aggregator builds the bars of a single trading pair for several intervals from its trades
+// Bars are aligned on multiples of their interval since the zero time, e.g. 1m bars start at
+// the beginning of every UTC minute, and are completed by the first trade of a later interval,
+// so intervals without any trade do not produce bars
+type Aggregator struct {
+	mux       *sync.Mutex
+	intervals []time.Duration
+	bars      []Bar
+}
+
+// NewAggregator creates a new Aggregator building bars for each of the given intervals
+// When no valid interval is provided, it builds bars for DefaultIntervals
+func NewAggregator(intervals ...time.Duration) *Aggregator {
+	var valid []time.Duration
+	for _, interval := range intervals {
+		if interval > 0 {
+			valid = append(valid, interval)
+		}
+	}
+	if len(valid) == 0 {
+		valid = append(valid, DefaultIntervals...)
+	}
+
+	return &Aggregator{
+		mux:       &sync.Mutex{},
+		intervals: valid,
+		bars:      make([]Bar, len(valid)),
+	}
+}
+
+// Push adds a trade to the current bar of every interval, and returns the bars completed by the trade
+// in the order of their intervals. Trades older than the current bar of an interval are ignored by it
+func (a *Aggregator) Push(price float64, volume float64, t time.Time, side vwap.Side) []Bar {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	var completed []Bar
+	for i, interval := range a.intervals {
+		b := &a.bars[i]
+		if b.Trades > 0 && t.Before(b.Start) {
+			continue
+		}
+
+		if b.Trades == 0 || !t.Before(b.End()) {
+			if b.Trades > 0 {
+				completed = append(completed, *b)
+			}
+			*b = Bar{Start: t.Truncate(interval), Interval: interval}
+		}
+		b.add(price, volume, side)
+	}
+
+	return completed
+}
+
+// Current returns the bars in progress, in the order of their intervals
+// Intervals which did not get any trade yet are left out
+func (a *Aggregator) Current() []Bar {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	var current []Bar
+	for _, b := range a.bars {
+		if b.Trades > 0 {
+			current = append(current, b)
+		}
+	}
+
+	return current
+}
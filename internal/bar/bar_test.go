@@ -0,0 +1,158 @@
+package bar
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"vwap-service/internal/vwap"
+)
+
+func TestNewAggregator(t *testing.T) {
+	tests := map[string]struct {
+		intervals []time.Duration
+		want      []time.Duration
+	}{
+		"it should fall back to the default intervals": {
+			intervals: nil,
+			want:      []time.Duration{time.Second, time.Minute, 5 * time.Minute, time.Hour},
+		},
+		"it should ignore invalid intervals": {
+			intervals: []time.Duration{0, time.Minute, -time.Second},
+			want:      []time.Duration{time.Minute},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := NewAggregator(tt.intervals...).intervals; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewAggregator() intervals = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregator_Push(t *testing.T) {
+	t0 := time.Date(2022, 1, 2, 15, 4, 0, 0, time.UTC)
+
+	type push struct {
+		price  float64
+		volume float64
+		time   time.Time
+		side   vwap.Side
+	}
+	tests := map[string]struct {
+		intervals     []time.Duration
+		pushes        []push
+		wantCompleted []Bar
+		wantCurrent   []Bar
+	}{
+		"it should build a bar from the trades of an interval": {
+			intervals: []time.Duration{time.Minute},
+			pushes: []push{
+				{4, 1, t0.Add(10 * time.Second), vwap.SideBuy},
+				{6, 2, t0.Add(20 * time.Second), vwap.SideSell},
+				{3, 1, t0.Add(30 * time.Second), vwap.SideBuy},
+				{5, 0, t0.Add(40 * time.Second), vwap.SideUnknown},
+			},
+			wantCurrent: []Bar{
+				{
+					Start: t0, Interval: time.Minute,
+					Open: 4, High: 6, Low: 3, Close: 5,
+					Volume: 4, VWAP: 19. / 4., Trades: 4, BuyVolume: 2, SellVolume: 2,
+					sumPQ: 19,
+				},
+			},
+		},
+		"it should complete a bar with the first trade of a later interval": {
+			intervals: []time.Duration{time.Minute},
+			pushes: []push{
+				{4, 1, t0.Add(10 * time.Second), vwap.SideBuy},
+				{6, 1, t0.Add(3*time.Minute + 5*time.Second), vwap.SideSell},
+			},
+			wantCompleted: []Bar{
+				{
+					Start: t0, Interval: time.Minute,
+					Open: 4, High: 4, Low: 4, Close: 4,
+					Volume: 1, VWAP: 4, Trades: 1, BuyVolume: 1,
+					sumPQ: 4,
+				},
+			},
+			wantCurrent: []Bar{
+				{
+					Start: t0.Add(3 * time.Minute), Interval: time.Minute,
+					Open: 6, High: 6, Low: 6, Close: 6,
+					Volume: 1, VWAP: 6, Trades: 1, SellVolume: 1,
+					sumPQ: 6,
+				},
+			},
+		},
+		"it should build the bars of every interval": {
+			intervals: []time.Duration{time.Second, time.Minute},
+			pushes: []push{
+				{4, 1, t0, vwap.SideBuy},
+				{6, 1, t0.Add(1500 * time.Millisecond), vwap.SideBuy},
+			},
+			wantCompleted: []Bar{
+				{
+					Start: t0, Interval: time.Second,
+					Open: 4, High: 4, Low: 4, Close: 4,
+					Volume: 1, VWAP: 4, Trades: 1, BuyVolume: 1,
+					sumPQ: 4,
+				},
+			},
+			wantCurrent: []Bar{
+				{
+					Start: t0.Add(time.Second), Interval: time.Second,
+					Open: 6, High: 6, Low: 6, Close: 6,
+					Volume: 1, VWAP: 6, Trades: 1, BuyVolume: 1,
+					sumPQ: 6,
+				},
+				{
+					Start: t0, Interval: time.Minute,
+					Open: 4, High: 6, Low: 4, Close: 6,
+					Volume: 2, VWAP: 5, Trades: 2, BuyVolume: 2,
+					sumPQ: 10,
+				},
+			},
+		},
+		"it should ignore trades older than the current bar": {
+			intervals: []time.Duration{time.Minute},
+			pushes: []push{
+				{4, 1, t0.Add(time.Minute), vwap.SideBuy},
+				{6, 1, t0.Add(30 * time.Second), vwap.SideBuy},
+			},
+			wantCurrent: []Bar{
+				{
+					Start: t0.Add(time.Minute), Interval: time.Minute,
+					Open: 4, High: 4, Low: 4, Close: 4,
+					Volume: 1, VWAP: 4, Trades: 1, BuyVolume: 1,
+					sumPQ: 4,
+				},
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := NewAggregator(tt.intervals...)
+
+			var completed []Bar
+			for _, p := range tt.pushes {
+				completed = append(completed, a.Push(p.price, p.volume, p.time, p.side)...)
+			}
+
+			if !reflect.DeepEqual(completed, tt.wantCompleted) {
+				t.Errorf("Push() completed = %+v, want %+v", completed, tt.wantCompleted)
+			}
+			if got := a.Current(); !reflect.DeepEqual(got, tt.wantCurrent) {
+				t.Errorf("Current() = %+v, want %+v", got, tt.wantCurrent)
+			}
+		})
+	}
+}
+
+func TestBar_End(t *testing.T) {
+	t0 := time.Date(2022, 1, 2, 15, 0, 0, 0, time.UTC)
+	b := Bar{Start: t0, Interval: 5 * time.Minute}
+	if got := b.End(); !got.Equal(t0.Add(5 * time.Minute)) {
+		t.Errorf("End() = %v, want %v", got, t0.Add(5*time.Minute))
+	}
+}
@@ -0,0 +1,22 @@
+package coinbase
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff returns the delay before the given reconnection attempt, starting at 0. The delay doubles from min
+// with every attempt up to max, and is randomized between half of it and all of it, so that clients disconnected
+// at the same time do not reconnect at the same time
+func backoff(min time.Duration, max time.Duration, attempt int) time.Duration {
+	d := min
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
@@ -0,0 +1,130 @@
+package coinbase
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Event is a message received from the server and decoded by the client, or an event sent by the client
+// itself in the feeds. It is one of Match, Error, Subscriptions, Heartbeat, Gap or Stale
+type Event interface {
+	EventType() string
+}
+
+// Match is a trade received from the matches channel, of TypeMatch, or of TypeLastMatch for the last trade
+// sent on every subscription
+type Match struct {
+	Type         string
+	TradeID      int
+	Sequence     int64
+	MakerOrderID string
+	TakerOrderID string
+	ProductID    string
+	// Price and Size are NaN when the server sent a value which is not a number
+	Price float64
+	Size  float64
+	// DecimalPrice and DecimalSize are the price and size exactly as sent by the server
+	DecimalPrice string
+	DecimalSize  string
+	// Side is the side of the maker order
+	Side string
+	Time time.Time
+}
+
+func (m Match) EventType() string {
+	return m.Type
+}
+
+// Error is sent by the server when a request is rejected, e.g. a subscription to an unknown product
+type Error struct {
+	Message string
+	Reason  string
+}
+
+func (e Error) EventType() string {
+	return TypeError
+}
+
+func (e Error) Error() string {
+	if e.Reason == "" {
+		return e.Message
+	}
+	return e.Message + ": " + e.Reason
+}
+
+// Subscriptions is sent by the server whenever the subscriptions change, with every active channel
+type Subscriptions struct {
+	Channels Channels
+}
+
+func (s Subscriptions) EventType() string {
+	return TypeSubscriptions
+}
+
+// Heartbeat is sent by the server every second for every product subscribed to the heartbeat channel,
+// along with the ID of its last trade
+type Heartbeat struct {
+	ProductID   string
+	Sequence    int64
+	LastTradeID int
+	Time        time.Time
+}
+
+func (h Heartbeat) EventType() string {
+	return TypeHeartbeat
+}
+
+func (g Gap) EventType() string {
+	return g.Type
+}
+
+func (s Stale) EventType() string {
+	return TypeStale
+}
+
+// decode decodes a message received from the server into its event, and returns a nil event for the messages
+// of other types
+func decode(msg []byte) (Event, error) {
+	m := Message{}
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return nil, fmt.Errorf("unmarshal message: %w", err)
+	}
+
+	switch m.Type {
+	case TypeMatch, TypeLastMatch:
+		return Match{
+			Type:         m.Type,
+			TradeID:      m.TradeID,
+			Sequence:     m.Sequence,
+			MakerOrderID: m.MakerOrderID,
+			TakerOrderID: m.TakerOrderID,
+			ProductID:    m.ProductID,
+			Price:        parseNumber(m.Price),
+			Size:         parseNumber(m.Size),
+			DecimalPrice: m.Price,
+			DecimalSize:  m.Size,
+			Side:         m.Side,
+			Time:         m.Time,
+		}, nil
+	case TypeError:
+		return Error{Message: m.Message, Reason: m.Reason}, nil
+	case TypeSubscriptions:
+		return Subscriptions{Channels: m.Channels}, nil
+	case TypeHeartbeat:
+		return Heartbeat{ProductID: m.ProductID, Sequence: m.Sequence, LastTradeID: m.LastTradeID, Time: m.Time}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// parseNumber parses a decimal number sent by the server, and returns NaN when it is not a number
+func parseNumber(s string) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
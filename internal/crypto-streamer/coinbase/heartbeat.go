@@ -0,0 +1,121 @@
+package coinbase
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// TypeHeartbeat is sent by the server every second for every product subscribed to the heartbeat channel
+	TypeHeartbeat = "heartbeat"
+	// TypeStale is sent by the client when a product missed its heartbeats, right before reconnecting
+	TypeStale = "stale"
+)
+
+// Stale is the event sent in the feeds by the client when no heartbeat of a product was received
+// for longer than the tolerance (see WithHeartbeat). LastHeartbeat is the time the last heartbeat was
+// received, or the time the client started to watch the product when none was
+type Stale struct {
+	Type          string
+	ProductID     string
+	LastHeartbeat time.Time
+	Time          time.Time
+}
+
+// watchdog tracks the reception time of the last heartbeat of every watched product, and records
+// the products which missed their heartbeats for longer than the tolerance
+type watchdog struct {
+	mu        sync.Mutex
+	tolerance time.Duration
+	last      map[string]time.Time
+	stale     []Stale
+	// tripped is set once stale products were found, until the client reconnects
+	tripped bool
+}
+
+func newWatchdog(tolerance time.Duration) *watchdog {
+	return &watchdog{
+		tolerance: tolerance,
+		last:      make(map[string]time.Time),
+	}
+}
+
+// watch starts watching the heartbeats of the given products from now
+func (d *watchdog) watch(now time.Time, productIDs ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, id := range productIDs {
+		if _, ok := d.last[id]; !ok {
+			d.last[id] = now
+		}
+	}
+}
+
+// unwatch stops watching the heartbeats of the given products
+func (d *watchdog) unwatch(productIDs ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, id := range productIDs {
+		delete(d.last, id)
+	}
+}
+
+// beat records a heartbeat of a watched product
+func (d *watchdog) beat(now time.Time, productID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.last[productID]; ok {
+		d.last[productID] = now
+	}
+}
+
+// reset restarts watching every product from now, once reconnected
+func (d *watchdog) reset(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for id := range d.last {
+		d.last[id] = now
+	}
+	d.stale = nil
+	d.tripped = false
+}
+
+// check records a stale event for every product whose last heartbeat is older than the tolerance,
+// and returns whether there was any. Stale products are only reported once until reset
+func (d *watchdog) check(now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.tripped {
+		return false
+	}
+
+	var ids []string
+	for id, last := range d.last {
+		if now.Sub(last) > d.tolerance {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		d.stale = append(d.stale, Stale{Type: TypeStale, ProductID: id, LastHeartbeat: d.last[id], Time: now})
+	}
+	d.tripped = len(ids) > 0
+	return d.tripped
+}
+
+// popStale returns the stale events recorded since the last call
+func (d *watchdog) popStale() []Stale {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stale := d.stale
+	d.stale = nil
+	return stale
+}
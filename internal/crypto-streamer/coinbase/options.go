@@ -2,11 +2,21 @@ package coinbase
 
 import (
 	"go.uber.org/zap"
+	"time"
 )
 
 type options struct {
-	logger *zap.Logger
-	wsUrl  string
+	logger        *zap.Logger
+	wsUrl         string
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	maxReconnects int
+	heartbeat     time.Duration
+	pingInterval  time.Duration
+	pongWait      time.Duration
+	writeTimeout  time.Duration
+	subscribeAck  time.Duration
+	gapRetention  time.Duration
 }
 
 type Option interface {
@@ -39,3 +49,147 @@ func (u wsUrlOption) apply(opts *options) {
 func WithWSUrl(url string) Option {
 	return wsUrlOption{Url: url}
 }
+
+type backoffOption struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+func (b backoffOption) apply(opts *options) {
+	opts.minBackoff = b.Min
+	opts.maxBackoff = b.Max
+}
+
+// WithBackoff sets the delay before reconnecting to the server, which starts at min and doubles after
+// every failed attempt up to max. Delays are randomized so that clients do not reconnect all at once.
+// Non-positive delays keep the defaults of 500ms and 30s
+func WithBackoff(min time.Duration, max time.Duration) Option {
+	if min <= 0 {
+		min = _minBackoff
+	}
+	if max <= 0 {
+		max = _maxBackoff
+	}
+	if max < min {
+		max = min
+	}
+	return backoffOption{Min: min, Max: max}
+}
+
+type maxReconnectsOption struct {
+	Attempts int
+}
+
+func (m maxReconnectsOption) apply(opts *options) {
+	opts.maxReconnects = m.Attempts
+}
+
+// WithMaxReconnects gives up reconnecting to the server after the given number of consecutive failed attempts,
+// and reports the failure to the feeds. 0 reconnects until the client is closed, a negative number never reconnects
+func WithMaxReconnects(attempts int) Option {
+	return maxReconnectsOption{Attempts: attempts}
+}
+
+type heartbeatOption struct {
+	Tolerance time.Duration
+}
+
+func (h heartbeatOption) apply(opts *options) {
+	opts.heartbeat = h.Tolerance
+}
+
+// WithHeartbeat subscribes to the heartbeat channel of every product subscribed to, and reconnects when
+// no heartbeat of a product was received for longer than tolerance, after sending a stale event in the feeds.
+// The server sends a heartbeat every second, a tolerance of 0 does not watch heartbeats
+func WithHeartbeat(tolerance time.Duration) Option {
+	if tolerance < 0 {
+		tolerance = 0
+	}
+	return heartbeatOption{Tolerance: tolerance}
+}
+
+type pingIntervalOption struct {
+	Interval time.Duration
+}
+
+func (p pingIntervalOption) apply(opts *options) {
+	opts.pingInterval = p.Interval
+}
+
+// WithPingInterval pings the server every interval while reading the feeds, 30s by default.
+// A non-positive interval never pings, and never times out reading the feeds
+func WithPingInterval(interval time.Duration) Option {
+	if interval < 0 {
+		interval = 0
+	}
+	return pingIntervalOption{Interval: interval}
+}
+
+type pongWaitOption struct {
+	Wait time.Duration
+}
+
+func (p pongWaitOption) apply(opts *options) {
+	opts.pongWait = p.Wait
+}
+
+// WithPongWait reconnects when no pong was received from the server for longer than wait while reading the feeds.
+// It is twice the ping interval by default, or when shorter than the ping interval
+func WithPongWait(wait time.Duration) Option {
+	if wait < 0 {
+		wait = 0
+	}
+	return pongWaitOption{Wait: wait}
+}
+
+type writeTimeoutOption struct {
+	Timeout time.Duration
+}
+
+func (w writeTimeoutOption) apply(opts *options) {
+	opts.writeTimeout = w.Timeout
+}
+
+// WithWriteTimeout fails writing to the server, e.g. subscribing, after the given timeout, 10s by default.
+// A non-positive timeout never times out
+func WithWriteTimeout(timeout time.Duration) Option {
+	if timeout < 0 {
+		timeout = 0
+	}
+	return writeTimeoutOption{Timeout: timeout}
+}
+
+type subscribeTimeoutOption struct {
+	Timeout time.Duration
+}
+
+func (s subscribeTimeoutOption) apply(opts *options) {
+	opts.subscribeAck = s.Timeout
+}
+
+// WithSubscribeTimeout fails subscribing when the server neither acknowledged nor rejected the subscription
+// within the given timeout, 5s by default. A non-positive timeout does not wait for the server
+func WithSubscribeTimeout(timeout time.Duration) Option {
+	if timeout < 0 {
+		timeout = 0
+	}
+	return subscribeTimeoutOption{Timeout: timeout}
+}
+
+type gapRetentionOption struct {
+	Retention time.Duration
+}
+
+func (g gapRetentionOption) apply(opts *options) {
+	opts.gapRetention = g.Retention
+}
+
+// WithGapRetention forgets the gaps whose trades were not all received late after the given retention, 24h by default,
+// which should be longer than the longest window computed from the feeds, and sends a TypeGapExpired event for each of them.
+// A non-positive retention keeps them until recovered
+func WithGapRetention(retention time.Duration) Option {
+	if retention < 0 {
+		retention = 0
+	}
+	return gapRetentionOption{Retention: retention}
+}
@@ -0,0 +1,165 @@
+package coinbase
+
+import (
+	"time"
+)
+
+const (
+	// TypeGap is sent by the client when it detects trades of a product which were not received
+	TypeGap = "gap"
+	// TypeGapRecovered is sent by the client once every trade of a gap was received late
+	TypeGapRecovered = "gap_recovered"
+	// TypeGapExpired is sent by the client once a gap is forgotten after the gap retention
+	TypeGapExpired = "gap_expired"
+)
+
+// Gap is the event sent in the feeds by the client when trades of a product were missed, from FirstTradeID
+// to LastTradeID included, once they were all received late, or once they are forgotten. Time is the trade time
+// of the message which revealed, recovered or expired the gap, and Sequence its sequence number along with the
+// last one received before it
+type Gap struct {
+	Type         string
+	ProductID    string
+	FirstTradeID int
+	LastTradeID  int
+	PrevSequence int64
+	Sequence     int64
+	Time         time.Time
+}
+
+// sequencer tracks the sequence numbers and trade IDs of the matches of every product, to detect
+// the trades missed between two matches, or while reconnecting thanks to the last_match message
+// sent on every subscription. Open gaps are forgotten once older than the retention
+type sequencer struct {
+	retention time.Duration
+	products  map[string]*productSequence
+}
+
+type productSequence struct {
+	sequence int64
+	tradeID  int
+	// gaps are the open gaps of the product, from the oldest to the newest
+	gaps []openGap
+}
+
+// openGap is a range of missed trades, along with the trades of the range received late
+type openGap struct {
+	first    int
+	last     int
+	time     time.Time
+	received map[int]struct{}
+}
+
+func newSequencer(retention time.Duration) *sequencer {
+	return &sequencer{retention: retention, products: make(map[string]*productSequence)}
+}
+
+// track records the sequence number and trade ID of a match or last_match, and returns the gap
+// events it reveals, closes or expires. It returns false when the match is a duplicate of a trade already received,
+// which must be dropped. Matches without a trade ID are not tracked
+func (s *sequencer) track(m Match) ([]Gap, bool) {
+	if m.TradeID == 0 || m.ProductID == "" {
+		return nil, true
+	}
+
+	p, ok := s.products[m.ProductID]
+	if !ok {
+		s.products[m.ProductID] = &productSequence{sequence: m.Sequence, tradeID: m.TradeID}
+		return nil, true
+	}
+
+	prevSequence := p.sequence
+	if m.Sequence > p.sequence {
+		p.sequence = m.Sequence
+	}
+
+	expired := p.expire(m, prevSequence, s.retention)
+	gaps, fresh := p.track(m, prevSequence)
+	return append(expired, gaps...), fresh
+}
+
+// track records the trade ID of a match of the product, and returns the gap events it reveals or closes
+func (p *productSequence) track(m Match, prevSequence int64) ([]Gap, bool) {
+	if m.TradeID > p.tradeID {
+		// the last match is the newest trade, which is missed along with the ones before it
+		last := m.TradeID - 1
+		if m.Type == TypeLastMatch {
+			last = m.TradeID
+		}
+
+		var gaps []Gap
+		if first := p.tradeID + 1; first <= last {
+			p.gaps = append(p.gaps, openGap{first: first, last: last, time: m.Time, received: make(map[int]struct{})})
+			gaps = append(gaps, Gap{
+				Type:         TypeGap,
+				ProductID:    m.ProductID,
+				FirstTradeID: first,
+				LastTradeID:  last,
+				PrevSequence: prevSequence,
+				Sequence:     m.Sequence,
+				Time:         m.Time,
+			})
+		}
+		p.tradeID = m.TradeID
+		return gaps, true
+	}
+
+	// an older trade is either a missed one received late, or a duplicate
+	if m.Type == TypeLastMatch {
+		return nil, false
+	}
+	for i, g := range p.gaps {
+		if m.TradeID < g.first || m.TradeID > g.last {
+			continue
+		}
+
+		if _, ok := g.received[m.TradeID]; ok {
+			return nil, false
+		}
+		g.received[m.TradeID] = struct{}{}
+		if len(g.received) < g.last-g.first+1 {
+			return nil, true
+		}
+
+		p.gaps = append(p.gaps[:i], p.gaps[i+1:]...)
+		return []Gap{{
+			Type:         TypeGapRecovered,
+			ProductID:    m.ProductID,
+			FirstTradeID: g.first,
+			LastTradeID:  g.last,
+			PrevSequence: prevSequence,
+			Sequence:     m.Sequence,
+			Time:         m.Time,
+		}}, true
+	}
+
+	return nil, false
+}
+
+// expire forgets the open gaps revealed longer than the retention before the match, whose trades no longer fall
+// within any window once received, and returns their expiry events. A non-positive retention keeps them until
+// recovered
+func (p *productSequence) expire(m Match, prevSequence int64, retention time.Duration) []Gap {
+	if retention <= 0 || m.Time.IsZero() {
+		return nil
+	}
+
+	var expired []Gap
+	n := 0
+	for ; n < len(p.gaps) && m.Time.Sub(p.gaps[n].time) > retention; n++ {
+		expired = append(expired, Gap{
+			Type:         TypeGapExpired,
+			ProductID:    m.ProductID,
+			FirstTradeID: p.gaps[n].first,
+			LastTradeID:  p.gaps[n].last,
+			PrevSequence: prevSequence,
+			Sequence:     m.Sequence,
+			Time:         m.Time,
+		})
+	}
+	if n > 0 {
+		p.gaps = append(p.gaps[:0], p.gaps[n:]...)
+	}
+
+	return expired
+}
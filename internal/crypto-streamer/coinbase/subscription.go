@@ -0,0 +1,123 @@
+package coinbase
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrSubscriptionTimeout is returned when the server neither acknowledged nor rejected a subscription in time
+var ErrSubscriptionTimeout = errors.New("subscription not acknowledged")
+
+// SubscriptionError is returned by Subscribe when the server rejected products of the channel. The server rejects
+// a subscription as a whole, so none of its products are subscribed to. ProductIDs are the products named as
+// invalid by the server, or every product of the subscription when none is named, and Message and Reason are those
+// of the error sent by the server, if any
+type SubscriptionError struct {
+	Channel    string
+	ProductIDs []string
+	Message    string
+	Reason     string
+}
+
+func (e *SubscriptionError) Error() string {
+	msg := "subscribe to " + e.Channel + ": rejected products " + strings.Join(e.ProductIDs, ",")
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// rejected returns the error of a subscription to the products of the channel from the answer of the server,
+// or nil when every product was subscribed to
+func rejected(channel string, productIDs []string, answer Event) *SubscriptionError {
+	switch a := answer.(type) {
+	case Error:
+		named := strings.FieldsFunc(a.Message+" "+a.Reason, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-'
+		})
+
+		var ids []string
+		for _, id := range productIDs {
+			if contains(named, id) {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			ids = append(ids, productIDs...)
+		}
+		return &SubscriptionError{Channel: channel, ProductIDs: ids, Message: a.Message, Reason: a.Reason}
+
+	case Subscriptions:
+		var ids []string
+		for _, id := range productIDs {
+			if !a.Channels.contains(channel, id) {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		return &SubscriptionError{Channel: channel, ProductIDs: ids, Message: "not subscribed"}
+
+	default:
+		return nil
+	}
+}
+
+// awaitAnswers makes the feeds pass the next answer of the server to the returned channel, and returns nil
+// when the answer cannot be awaited, as the feeds are not read or the subscriptions are not awaited.
+// It must be called while holding mu
+func (w *WSClient) awaitAnswers() chan Event {
+	if !w.reading || w.subscribeTimeout <= 0 {
+		return nil
+	}
+
+	w.answers = make(chan Event, 1)
+	return w.answers
+}
+
+// awaitAnswer waits for the answer of the server to a subscription, passed by the feeds to answers
+func (w *WSClient) awaitAnswer(answers <-chan Event) (Event, error) {
+	defer func() {
+		w.mu.Lock()
+		w.answers = nil
+		w.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(w.subscribeTimeout)
+	defer timer.Stop()
+
+	select {
+	case answer := <-answers:
+		return answer, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("%w within %s", ErrSubscriptionTimeout, w.subscribeTimeout)
+	case <-w.ctx.Done():
+		return nil, w.ctx.Err()
+	}
+}
+
+// answer records the channels subscribed to from a subscriptions message of the server, and passes it along
+// with error messages to the subscription awaiting them, if any
+func (w *WSClient) answer(event Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if s, ok := event.(Subscriptions); ok {
+		w.subscriptions = s.Channels.copy()
+	}
+
+	if w.answers == nil {
+		return
+	}
+	select {
+	case w.answers <- event:
+	default:
+	}
+}
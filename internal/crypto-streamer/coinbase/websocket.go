@@ -2,17 +2,24 @@ package coinbase
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	ws "github.com/gorilla/websocket"
 	"go.uber.org/zap"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	_reqLimitsPerSec = 100
 	_wsUrl           = "wss://ws-feed.exchange.coinbase.com"
+	_minBackoff      = 500 * time.Millisecond
+	_maxBackoff      = 30 * time.Second
+	_pingInterval    = 30 * time.Second
+	_writeTimeout    = 10 * time.Second
+	_subscribeAck    = 5 * time.Second
+	_gapRetention    = 24 * time.Hour
 )
 
 const (
@@ -42,34 +49,84 @@ type Message struct {
 	Size         string    `json:"size,omitempty"`
 	Price        string    `json:"price,omitempty"`
 	Message      string    `json:"message,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	LastTradeID  int       `json:"last_trade_id,omitempty"`
 	Side         string    `json:"side,omitempty"`
 	Channels     Channels  `json:"channels,omitempty"`
 }
 
 // WSClient is the Websocket client used by Coinbase to subscribe to channels
+// When the connection is lost, the client reconnects with an exponential backoff (see WithBackoff)
+// and subscribes again to every active subscription, so that its feeds keep delivering messages
+// While the feeds are read, subscriptions wait for the server to acknowledge or reject them (see WithSubscribeTimeout)
 type WSClient struct {
 	ctx    context.Context
-	conn   *ws.Conn
 	url    string
 	logger *zap.Logger
+
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	maxReconnects int
+	pingInterval  time.Duration
+	pongWait      time.Duration
+	writeTimeout  time.Duration
+
+	subscribeTimeout time.Duration
+	// subscribing serializes the subscriptions, so that every answer of the server is matched to its request
+	subscribing sync.Mutex
+
+	// sequencer is only used by the feeds goroutine
+	sequencer *sequencer
+	// watchdog watches the heartbeats of the products subscribed to, it is nil unless enabled
+	watchdog *watchdog
+
+	// mu guards the connection, which is replaced when reconnecting, along with the writes to it
+	mu            sync.Mutex
+	conn          *ws.Conn
+	closed        bool
+	reading       bool
+	subscriptions Channels
+	// answers receives the next subscriptions or error message read by the feeds, while a subscription awaits it
+	answers chan Event
 }
 
 // NewClient creates a new websocket client with an established connection
 // to the websocket server
 func NewClient(ctx context.Context, opts ...Option) (*WSClient, error) {
 	options := options{
-		logger: zap.NewNop(),
-		wsUrl:  _wsUrl,
+		logger:       zap.NewNop(),
+		wsUrl:        _wsUrl,
+		minBackoff:   _minBackoff,
+		maxBackoff:   _maxBackoff,
+		pingInterval: _pingInterval,
+		writeTimeout: _writeTimeout,
+		subscribeAck: _subscribeAck,
+		gapRetention: _gapRetention,
 	}
 
 	for _, o := range opts {
 		o.apply(&options)
 	}
 
+	if options.pongWait < options.pingInterval {
+		options.pongWait = 2 * options.pingInterval
+	}
+
 	client := &WSClient{
-		ctx:    ctx,
-		url:    options.wsUrl,
-		logger: options.logger,
+		ctx:              ctx,
+		url:              options.wsUrl,
+		logger:           options.logger,
+		minBackoff:       options.minBackoff,
+		maxBackoff:       options.maxBackoff,
+		maxReconnects:    options.maxReconnects,
+		pingInterval:     options.pingInterval,
+		pongWait:         options.pongWait,
+		writeTimeout:     options.writeTimeout,
+		subscribeTimeout: options.subscribeAck,
+		sequencer:        newSequencer(options.gapRetention),
+	}
+	if options.heartbeat > 0 {
+		client.watchdog = newWatchdog(options.heartbeat)
 	}
 
 	if err := client.dial(); err != nil {
@@ -79,61 +136,156 @@ func NewClient(ctx context.Context, opts ...Option) (*WSClient, error) {
 	return client, nil
 }
 
-// Subscribe subscribes to the provided channels and product ids
+// Subscribe subscribes to the provided channels and product ids, along with their heartbeats when watched
+// The subscription is replayed whenever the client reconnects, until unsubscribed
+// While the feeds are read, it waits for the server to acknowledge the subscription, and returns
+// a *SubscriptionError when products are rejected, or an error wrapping ErrSubscriptionTimeout
 func (w *WSClient) Subscribe(channel string, productIDs ...string) error {
 	reqMsg := Message{
-		Type: Subscribe,
-		Channels: []Channel{
-			{
-				Name:       channel,
-				ProductIDs: productIDs,
-			},
-		},
+		Type:     Subscribe,
+		Channels: w.withHeartbeat(channel, productIDs),
+	}
+
+	w.subscribing.Lock()
+	defer w.subscribing.Unlock()
+
+	answers, err := w.request(reqMsg)
+	if err != nil {
+		return err
+	}
+
+	// the subscriptions are recorded from the answer of the server when awaited
+	if answers == nil {
+		w.mu.Lock()
+		for _, ch := range reqMsg.Channels {
+			w.subscriptions = w.subscriptions.add(ch.Name, ch.ProductIDs...)
+		}
+		w.mu.Unlock()
+	} else {
+		answer, err := w.awaitAnswer(answers)
+		if err != nil {
+			return fmt.Errorf("subscribe to %s: %w", channel, err)
+		}
+		if subErr := rejected(channel, productIDs, answer); subErr != nil {
+			return subErr
+		}
 	}
-	if err := w.conn.WriteJSON(reqMsg); err != nil {
-		return fmt.Errorf("write message: %w", err)
+
+	if w.watchdog != nil {
+		w.watchdog.watch(time.Now(), productIDs...)
 	}
 
 	return nil
 }
 
-// Unsubscribe unsubscribes from the provided channels and products ids
+// Unsubscribe unsubscribes from the provided channels and products ids, along with their heartbeats when watched
+// While the feeds are read, it waits for the server to acknowledge it
 func (w *WSClient) Unsubscribe(channel string, productIDs ...string) error {
 	reqMsg := Message{
-		Type: Unsubscribe,
-		Channels: []Channel{
-			{
-				Name:       channel,
-				ProductIDs: productIDs,
-			},
-		},
+		Type:     Unsubscribe,
+		Channels: w.withHeartbeat(channel, productIDs),
+	}
+
+	w.subscribing.Lock()
+	defer w.subscribing.Unlock()
+
+	answers, err := w.request(reqMsg)
+	if err != nil {
+		return err
 	}
-	if err := w.conn.WriteJSON(reqMsg); err != nil {
-		return fmt.Errorf("write message: %w", err)
+
+	w.mu.Lock()
+	for _, ch := range reqMsg.Channels {
+		w.subscriptions = w.subscriptions.remove(ch.Name, ch.ProductIDs...)
+	}
+	w.mu.Unlock()
+	if w.watchdog != nil {
+		w.watchdog.unwatch(productIDs...)
+	}
+
+	if answers == nil {
+		return nil
+	}
+	answer, err := w.awaitAnswer(answers)
+	if err != nil {
+		return fmt.Errorf("unsubscribe from %s: %w", channel, err)
+	}
+	if e, ok := answer.(Error); ok {
+		return fmt.Errorf("unsubscribe from %s: %w", channel, e)
 	}
 
 	return nil
 }
 
-// Close closes the connection to the server
+// request writes a subscription request to the server, and returns the channel receiving its answer
+// when it can be awaited
+func (w *WSClient) request(reqMsg Message) (chan Event, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	answers := w.awaitAnswers()
+	if err := w.writeJSON(w.conn, reqMsg); err != nil {
+		w.answers = nil
+		return nil, fmt.Errorf("write message: %w", err)
+	}
+
+	return answers, nil
+}
+
+// Subscriptions returns the channels and product ids currently subscribed to, as last acknowledged by the server
+// while the feeds are read
+func (w *WSClient) Subscriptions() Channels {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.subscriptions.copy()
+}
+
+// withHeartbeat returns the channel with the given product ids, followed by the heartbeat channel
+// of the same products when heartbeats are watched
+func (w *WSClient) withHeartbeat(channel string, productIDs []string) Channels {
+	channels := Channels{NewChannel(channel, productIDs...)}
+	if w.watchdog != nil && channel != ChannelHeartbeat {
+		channels = append(channels, NewChannel(ChannelHeartbeat, productIDs...))
+	}
+	return channels
+}
+
+// Close closes the connection to the server, which is then never reconnected
 func (w *WSClient) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
 	if err := w.conn.Close(); err != nil {
 		return fmt.Errorf("close connection: %w", err)
 	}
 	return nil
 }
 
-// Feeds sends new messages to the receiver channel
-func (w *WSClient) Feeds() (feeds chan []byte, errors chan error) {
+// Feeds sends the events decoded from new messages to the receiver channel, along with the gap and stale
+// events of the client. Messages of other types are dropped
+func (w *WSClient) Feeds() (feeds chan Event, errors chan error) {
 	errors = make(chan error, 1)
-	feeds = make(chan []byte)
+	feeds = make(chan Event)
+	w.setReading(true)
 
 	go func() {
 		defer func() {
+			w.setReading(false)
 			close(errors)
 			close(feeds)
 		}()
 
+		done := make(chan struct{})
+		defer close(done)
+		if w.watchdog != nil {
+			go w.watchHeartbeats(done)
+		}
+		if w.pingInterval > 0 {
+			go w.ping(done)
+		}
+
 		// The server is rate limited to 100 requests / second per IP address
 		// This limit could be reached when subscribing to several products with high amounts of trades.
 		// We expectedClient to log whenever we exceed this limit and may decide to do something about it in the future if this
@@ -153,29 +305,67 @@ func (w *WSClient) Feeds() (feeds chan []byte, errors chan error) {
 				nReqsPerSec = 0
 
 			case <-w.ctx.Done():
-				if err := w.conn.Close(); err != nil {
+				if err := w.connection().Close(); err != nil {
 					errors <- fmt.Errorf("close connection: %w", err)
 				}
 				return
 
 			default:
-				_, msg, err := w.conn.ReadMessage()
+				_, msg, err := w.connection().ReadMessage()
 				if err != nil {
-					errors <- fmt.Errorf("read message: %w", err)
-					return
-				}
+					for _, stale := range w.popStale() {
+						feeds <- stale
+					}
+
+					if w.isClosed() || w.maxReconnects < 0 {
+						errors <- fmt.Errorf("read message: %w", err)
+						return
+					}
 
-				subMsg := Message{}
-				if err = json.Unmarshal(msg, &subMsg); err != nil {
-					w.logger.Sugar().Errorf("failed to unmarshal message: %v", err)
-				} else {
-					if subMsg.Type == TypeSubscriptions {
-						w.logger.Info("subscription updated", zap.Any("channels", subMsg.Channels))
+					w.logger.Warn("connection lost, reconnecting", zap.NamedError("error", err))
+					if err := w.reconnect(); err != nil {
+						if w.ctx.Err() == nil {
+							errors <- fmt.Errorf("read message: %w", err)
+						}
+						return
 					}
+					continue
 				}
 
-				feeds <- msg
 				nReqsPerSec++
+
+				event, err := decode(msg)
+				if err != nil {
+					w.logger.Error("failed to decode message", zap.NamedError("error", err), zap.ByteString("msg", msg))
+					continue
+				}
+
+				switch e := event.(type) {
+				case nil:
+					continue
+				case Subscriptions:
+					w.logger.Info("subscription updated", zap.Any("channels", e.Channels))
+					w.answer(e)
+				case Error:
+					w.answer(e)
+				case Heartbeat:
+					if w.watchdog != nil {
+						w.watchdog.beat(time.Now(), e.ProductID)
+					}
+				case Match:
+					gaps, fresh := w.sequencer.track(e)
+					for _, gap := range gaps {
+						w.logGap(gap)
+						feeds <- gap
+					}
+					if !fresh {
+						w.logger.Debug("dropped duplicate trade", zap.String("product_id", e.ProductID),
+							zap.Int("trade_id", e.TradeID))
+						continue
+					}
+				}
+
+				feeds <- event
 			}
 		}
 	}()
@@ -191,10 +381,191 @@ func (w *WSClient) dial() error {
 	if err != nil {
 		return fmt.Errorf("dial ws server %s: %w", w.url, err)
 	}
+	w.keepAlive(w.conn)
 
 	return nil
 }
 
+// keepAlive makes reading from the connection time out when no pong was received within the pong wait,
+// and must be called before reading from it. It does nothing when pings are disabled
+func (w *WSClient) keepAlive(conn *ws.Conn) {
+	if w.pingInterval <= 0 {
+		return
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(w.pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(w.pongWait))
+	})
+}
+
+// ping pings the server every ping interval until done is closed. Pongs are handled while reading the feeds
+func (w *WSClient) ping(done <-chan struct{}) {
+	ticker := time.NewTicker(w.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+			deadline := time.Now().Add(w.pingInterval)
+			if w.writeTimeout > 0 {
+				deadline = time.Now().Add(w.writeTimeout)
+			}
+			if err := w.connection().WriteControl(ws.PingMessage, nil, deadline); err != nil {
+				w.logger.Debug("failed to ping server", zap.NamedError("error", err))
+			}
+		}
+	}
+}
+
+// writeJSON writes a message to the connection within the write timeout, and must be called while holding mu
+func (w *WSClient) writeJSON(conn *ws.Conn, v interface{}) error {
+	if w.writeTimeout > 0 {
+		if err := conn.SetWriteDeadline(time.Now().Add(w.writeTimeout)); err != nil {
+			return err
+		}
+	}
+	return conn.WriteJSON(v)
+}
+
+// watchHeartbeats checks the heartbeats of the watched products until done is closed, and closes the connection
+// when any of them is stale, so that the feeds reconnect
+func (w *WSClient) watchHeartbeats(done <-chan struct{}) {
+	interval := w.watchdog.tolerance / 4
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-w.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !w.watchdog.check(now) {
+				continue
+			}
+
+			w.logger.Warn("stale feed, no heartbeat received", zap.Duration("tolerance", w.watchdog.tolerance))
+			if err := w.connection().Close(); err != nil {
+				w.logger.Error("failed to close stale connection", zap.NamedError("error", err))
+			}
+		}
+	}
+}
+
+// popStale returns the stale events recorded by the watchdog, if any
+func (w *WSClient) popStale() []Stale {
+	if w.watchdog == nil {
+		return nil
+	}
+	return w.watchdog.popStale()
+}
+
+// logGap logs a gap detected, recovered or expired in the trades of a product
+func (w *WSClient) logGap(gap Gap) {
+	fields := []zap.Field{
+		zap.String("product_id", gap.ProductID),
+		zap.Int("first_trade_id", gap.FirstTradeID),
+		zap.Int("last_trade_id", gap.LastTradeID),
+	}
+	switch gap.Type {
+	case TypeGapRecovered:
+		w.logger.Info("trades gap recovered", fields...)
+	case TypeGapExpired:
+		w.logger.Info("trades gap expired", fields...)
+	default:
+		w.logger.Warn("trades gap detected", fields...)
+	}
+}
+
+// connection returns the current connection to the server
+func (w *WSClient) connection() *ws.Conn {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.conn
+}
+
+func (w *WSClient) isClosed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.closed
+}
+
+func (w *WSClient) setReading(reading bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.reading = reading
+}
+
+// reconnect dials the server again after an exponential backoff until it succeeds, the client is closed,
+// or maxReconnects consecutive attempts failed, and replays the active subscriptions on the new connection
+func (w *WSClient) reconnect() error {
+	for attempt := 0; w.maxReconnects == 0 || attempt < w.maxReconnects; attempt++ {
+		select {
+		case <-w.ctx.Done():
+			return fmt.Errorf("reconnect: %w", w.ctx.Err())
+		case <-time.After(backoff(w.minBackoff, w.maxBackoff, attempt)):
+		}
+
+		conn, _, err := ws.DefaultDialer.DialContext(w.ctx, w.url, nil)
+		if err != nil {
+			w.logger.Warn("failed to reconnect", zap.Int("attempt", attempt+1), zap.NamedError("error", err))
+			continue
+		}
+		w.keepAlive(conn)
+
+		if err := w.replaceConn(conn); err != nil {
+			conn.Close()
+			if w.isClosed() {
+				return err
+			}
+			w.logger.Warn("failed to reconnect", zap.Int("attempt", attempt+1), zap.NamedError("error", err))
+			continue
+		}
+
+		if w.watchdog != nil {
+			w.watchdog.reset(time.Now())
+		}
+		w.logger.Info("reconnected", zap.Int("attempt", attempt+1), zap.Stringer("channels", w.Subscriptions()))
+		return nil
+	}
+
+	return fmt.Errorf("reconnect to ws server %s: gave up after %d attempts", w.url, w.maxReconnects)
+}
+
+// replaceConn subscribes to the active subscriptions on the new connection, and replaces the lost connection with it
+func (w *WSClient) replaceConn(conn *ws.Conn) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errors.New("reconnect: client closed")
+	}
+
+	if len(w.subscriptions) > 0 {
+		reqMsg := Message{Type: Subscribe, Channels: w.subscriptions.copy()}
+		if err := w.writeJSON(conn, reqMsg); err != nil {
+			return fmt.Errorf("replay subscriptions: %w", err)
+		}
+	}
+
+	w.conn.Close()
+	w.conn = conn
+	return nil
+}
+
 // Channel represents a single element in the channels property in a Message
 type Channel struct {
 	Name       string   `json:"name"`
@@ -225,3 +596,78 @@ func (ch Channels) String() string {
 
 	return out
 }
+
+// add returns the channels with the given product ids added to the named channel
+func (ch Channels) add(name string, productIDs ...string) Channels {
+	for i := range ch {
+		if ch[i].Name != name {
+			continue
+		}
+		for _, id := range productIDs {
+			if !contains(ch[i].ProductIDs, id) {
+				ch[i].ProductIDs = append(ch[i].ProductIDs, id)
+			}
+		}
+		return ch
+	}
+
+	return append(ch, NewChannel(name, append([]string(nil), productIDs...)...))
+}
+
+// remove returns the channels without the given product ids of the named channel, which is
+// removed once it has no product id left, or when no product id is given
+func (ch Channels) remove(name string, productIDs ...string) Channels {
+	kept := ch[:0]
+	for _, channel := range ch {
+		if channel.Name == name && len(productIDs) == 0 {
+			continue
+		}
+		if channel.Name == name {
+			var ids []string
+			for _, id := range channel.ProductIDs {
+				if !contains(productIDs, id) {
+					ids = append(ids, id)
+				}
+			}
+			if len(ids) == 0 {
+				continue
+			}
+			channel.ProductIDs = ids
+		}
+		kept = append(kept, channel)
+	}
+
+	return kept
+}
+
+// copy returns a deep copy of the channels
+func (ch Channels) copy() Channels {
+	if ch == nil {
+		return nil
+	}
+
+	out := make(Channels, 0, len(ch))
+	for _, channel := range ch {
+		out = append(out, NewChannel(channel.Name, append([]string(nil), channel.ProductIDs...)...))
+	}
+	return out
+}
+
+// contains returns whether the named channel has the given product id
+func (ch Channels) contains(name string, productID string) bool {
+	for _, channel := range ch {
+		if channel.Name == name && contains(channel.ProductIDs, productID) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
@@ -2,15 +2,17 @@ package coinbase
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"go.uber.org/zap/zaptest/observer"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -57,6 +59,12 @@ var (
 			Size:      "0.4",
 		},
 	}
+
+	// matchEvents are the events decoded from the first matches
+	matchEvents = []Event{
+		Match{Type: TypeMatch, Sequence: 1, ProductID: "ETH-BTC", Price: 1, Size: 0.1, DecimalPrice: "1.0", DecimalSize: "0.1"},
+		Match{Type: TypeMatch, Sequence: 2, ProductID: "BTC-USD", Price: 2, Size: 0.2, DecimalPrice: "2.0", DecimalSize: "0.2"},
+	}
 )
 
 func echo(t *testing.T) http.HandlerFunc {
@@ -298,13 +306,16 @@ func TestWSClient_Unsubscribe(t *testing.T) {
 func TestWSClient_Feeds_should_succeed(t *testing.T) {
 	tests := map[string]struct {
 		messages []Message
+		events   []Event
 		logger   *zap.Logger
 	}{
 		"should successfully return messages": {
 			messages: matches[:2],
+			events:   matchEvents,
 		},
 		"should successfully subscribe": {
 			messages: []Message{subscriptionsMsg},
+			events:   []Event{Subscriptions{Channels: channels}},
 		},
 	}
 	for name, tt := range tests {
@@ -326,21 +337,16 @@ func TestWSClient_Feeds_should_succeed(t *testing.T) {
 
 			feeds, errFeeds := w.Feeds()
 
-			for _, m := range tt.messages {
+			for i, m := range tt.messages {
 				w.conn.WriteJSON(m)
 
 				select {
 				case <-time.Tick(1 * time.Second):
 					t.Fatalf("timed out waiting for feed")
-				case msg := <-feeds:
-					subMsg := Message{}
-					if err = json.Unmarshal(msg, &subMsg); err != nil {
-						t.Fatalf("error unmarshalling response")
-					}
-
+				case event := <-feeds:
 					// assert that we log subscriptions
 					allLogs := observedLogs.All()
-					if subMsg.Type == TypeSubscriptions {
+					if event.EventType() == TypeSubscriptions {
 						assert.Equal(t, "subscription updated", allLogs[0].Message)
 						assert.ElementsMatch(t, []zap.Field{
 							{Key: "channels", Type: zapcore.StringerType, Interface: channels},
@@ -349,7 +355,7 @@ func TestWSClient_Feeds_should_succeed(t *testing.T) {
 						assert.Len(t, allLogs, 0)
 					}
 
-					assert.Equal(t, m, subMsg)
+					assert.Equal(t, tt.events[i], event)
 				case feedErr := <-errFeeds:
 					t.Errorf("did not expect an error. got %v", feedErr)
 				}
@@ -383,3 +389,714 @@ func TestWSClient_Feeds_should_error_when_connection_closed(t *testing.T) {
 		assert.Contains(t, feedErr.Error(), "read message: read tcp")
 	}
 }
+
+// firstConnection is how a test server handles the first connection once it received its first message
+type firstConnection int
+
+const (
+	// dropFirst closes the first connection
+	dropFirst firstConnection = iota
+	// silenceFirst keeps the first connection open without ever sending anything
+	silenceFirst
+)
+
+// reconnecting returns a handler which forwards the first message of every connection to received, handles
+// the first connection as given, and sends the given messages on the next ones before echoing
+func reconnecting(t *testing.T, first firstConnection, received chan<- Message, messages ...Message) http.HandlerFunc {
+	t.Helper()
+
+	var mu sync.Mutex
+	nConns := 0
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := websocket.Upgrader{}
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		mu.Lock()
+		nConns++
+		isFirst := nConns == 1
+		mu.Unlock()
+
+		msg := Message{}
+		if err := c.ReadJSON(&msg); err != nil {
+			return
+		}
+		received <- msg
+
+		if isFirst {
+			if first == dropFirst {
+				return
+			}
+			for {
+				if _, _, err := c.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}
+
+		for _, m := range messages {
+			if err := c.WriteJSON(m); err != nil {
+				return
+			}
+		}
+		for {
+			mt, message, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := c.WriteMessage(mt, message); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestWSClient_Feeds_should_reconnect_and_resubscribe(t *testing.T) {
+	received := make(chan Message, 2)
+	server := httptest.NewServer(reconnecting(t, dropFirst, received, matches[0]))
+	defer server.Close()
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the server does not acknowledge subscriptions
+	w, err := NewClient(ctx, WithWSUrl(wsUrl), WithBackoff(10*time.Millisecond, 20*time.Millisecond), WithSubscribeTimeout(0))
+	if err != nil {
+		t.Fatalf("client should not be nil")
+	}
+	defer w.Close()
+
+	feeds, errFeeds := w.Feeds()
+	assert.NoError(t, w.Subscribe(ChannelMatches, productIDs...))
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for feed")
+	case event := <-feeds:
+		assert.Equal(t, matchEvents[0], event)
+	case feedErr := <-errFeeds:
+		t.Fatalf("did not expect an error. got %v", feedErr)
+	}
+
+	// the subscription is replayed on the new connection
+	wantSubscribe := Message{Type: Subscribe, Channels: Channels{NewChannel(ChannelMatches, productIDs...)}}
+	assert.Equal(t, wantSubscribe, <-received)
+	assert.Equal(t, wantSubscribe, <-received)
+}
+
+func TestWSClient_Feeds_should_error_after_max_reconnects(t *testing.T) {
+	received := make(chan Message, 1)
+	server := httptest.NewServer(reconnecting(t, dropFirst, received))
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewClient(ctx, WithWSUrl(wsUrl), WithBackoff(time.Millisecond, time.Millisecond), WithMaxReconnects(2),
+		WithSubscribeTimeout(0))
+	if err != nil {
+		t.Fatalf("client should not be nil")
+	}
+	defer w.Close()
+
+	_, errFeeds := w.Feeds()
+	assert.NoError(t, w.Subscribe(ChannelMatches, productIDs...))
+
+	// the server drops the connection, and then refuses new ones
+	<-received
+	server.Close()
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for feed error")
+	case feedErr := <-errFeeds:
+		assert.EqualError(t, feedErr, "read message: reconnect to ws server "+wsUrl+": gave up after 2 attempts")
+	}
+}
+
+func Test_backoff(t *testing.T) {
+	tests := map[string]struct {
+		attempt int
+		want    time.Duration
+	}{
+		"it should start at min":         {attempt: 0, want: 100 * time.Millisecond},
+		"it should double every attempt": {attempt: 2, want: 400 * time.Millisecond},
+		"it should not exceed max":       {attempt: 10, want: time.Second},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				got := backoff(100*time.Millisecond, time.Second, tt.attempt)
+				if got < tt.want/2 || got > tt.want {
+					t.Fatalf("backoff() = %v, want between %v and %v", got, tt.want/2, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestChannels_add_remove(t *testing.T) {
+	ch := Channels{}.
+		add(ChannelMatches, "BTC-USD").
+		add(ChannelHeartbeat, "BTC-USD").
+		add(ChannelMatches, "BTC-USD", "ETH-BTC")
+	assert.Equal(t, Channels{
+		NewChannel(ChannelMatches, "BTC-USD", "ETH-BTC"),
+		NewChannel(ChannelHeartbeat, "BTC-USD"),
+	}, ch)
+
+	ch = ch.remove(ChannelMatches, "BTC-USD")
+	assert.Equal(t, Channels{
+		NewChannel(ChannelMatches, "ETH-BTC"),
+		NewChannel(ChannelHeartbeat, "BTC-USD"),
+	}, ch)
+
+	ch = ch.remove(ChannelHeartbeat)
+	assert.Equal(t, Channels{NewChannel(ChannelMatches, "ETH-BTC")}, ch)
+
+	ch = ch.remove(ChannelMatches, "ETH-BTC")
+	assert.Empty(t, ch)
+}
+
+func Test_sequencer_track(t *testing.T) {
+	t0 := time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC)
+	match := func(typ string, tradeID int, sequence int64) Match {
+		return Match{Type: typ, ProductID: "BTC-USD", TradeID: tradeID, Sequence: sequence, Time: t0}
+	}
+
+	tests := map[string]struct {
+		messages  []Match
+		msg       Match
+		wantGaps  []Gap
+		wantFresh bool
+	}{
+		"it should not report a gap for the first trade": {
+			msg:       match(TypeMatch, 10, 100),
+			wantFresh: true,
+		},
+		"it should not report a gap for the next trade": {
+			messages:  []Match{match(TypeMatch, 10, 100)},
+			msg:       match(TypeMatch, 11, 105),
+			wantFresh: true,
+		},
+		"it should report the trades missed between two matches": {
+			messages: []Match{match(TypeMatch, 10, 100)},
+			msg:      match(TypeMatch, 13, 120),
+			wantGaps: []Gap{
+				{Type: TypeGap, ProductID: "BTC-USD", FirstTradeID: 11, LastTradeID: 12, PrevSequence: 100, Sequence: 120, Time: t0},
+			},
+			wantFresh: true,
+		},
+		"it should report the trades missed up to the last match": {
+			messages: []Match{match(TypeMatch, 10, 100)},
+			msg:      match(TypeLastMatch, 12, 120),
+			wantGaps: []Gap{
+				{Type: TypeGap, ProductID: "BTC-USD", FirstTradeID: 11, LastTradeID: 12, PrevSequence: 100, Sequence: 120, Time: t0},
+			},
+			wantFresh: true,
+		},
+		"it should not report a gap when the last match was received": {
+			messages:  []Match{match(TypeMatch, 10, 100)},
+			msg:       match(TypeLastMatch, 10, 100),
+			wantFresh: false,
+		},
+		"it should report a gap recovered once its trades were received late": {
+			messages:  []Match{match(TypeMatch, 10, 100), match(TypeMatch, 13, 120), match(TypeMatch, 12, 115)},
+			msg:       match(TypeMatch, 11, 110),
+			wantGaps:  []Gap{{Type: TypeGapRecovered, ProductID: "BTC-USD", FirstTradeID: 11, LastTradeID: 12, PrevSequence: 120, Sequence: 110, Time: t0}},
+			wantFresh: true,
+		},
+		"it should drop a duplicate of a trade received late": {
+			messages:  []Match{match(TypeMatch, 1, 10), match(TypeMatch, 4, 40), match(TypeMatch, 2, 20)},
+			msg:       match(TypeMatch, 2, 20),
+			wantFresh: false,
+		},
+		"it should recover a gap once every missed trade was received, whatever the duplicates": {
+			messages:  []Match{match(TypeMatch, 1, 10), match(TypeMatch, 4, 40), match(TypeMatch, 2, 20), match(TypeMatch, 2, 20)},
+			msg:       match(TypeMatch, 3, 30),
+			wantGaps:  []Gap{{Type: TypeGapRecovered, ProductID: "BTC-USD", FirstTradeID: 2, LastTradeID: 3, PrevSequence: 40, Sequence: 30, Time: t0}},
+			wantFresh: true,
+		},
+		"it should drop a duplicate trade": {
+			messages:  []Match{match(TypeMatch, 10, 100), match(TypeMatch, 11, 110)},
+			msg:       match(TypeMatch, 11, 110),
+			wantFresh: false,
+		},
+		"it should not track messages without a trade ID": {
+			messages:  []Match{match(TypeMatch, 10, 100)},
+			msg:       Match{Type: TypeMatch, ProductID: "BTC-USD"},
+			wantFresh: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := newSequencer(time.Hour)
+			for _, m := range tt.messages {
+				s.track(m)
+			}
+
+			gaps, fresh := s.track(tt.msg)
+			assert.Equal(t, tt.wantGaps, gaps)
+			assert.Equal(t, tt.wantFresh, fresh)
+		})
+	}
+}
+
+func Test_sequencer_track_expire(t *testing.T) {
+	t0 := time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC)
+	match := func(tradeID int, t time.Time) Match {
+		return Match{Type: TypeMatch, ProductID: "BTC-USD", TradeID: tradeID, Sequence: int64(tradeID), Time: t}
+	}
+
+	s := newSequencer(time.Hour)
+	s.track(match(1, t0))
+	gaps, _ := s.track(match(4, t0))
+	assert.Len(t, gaps, 1)
+	s.track(match(2, t0.Add(time.Minute)))
+
+	// the gap is still open within the retention
+	gaps, fresh := s.track(match(3, t0.Add(time.Minute)))
+	assert.Equal(t, TypeGapRecovered, gaps[0].Type)
+	assert.True(t, fresh)
+
+	s.track(match(7, t0.Add(time.Minute)))
+	assert.Len(t, s.products["BTC-USD"].gaps, 1)
+
+	// the gap revealed by trade 7 is forgotten once older than the retention, along with its late trades
+	gaps, fresh = s.track(match(8, t0.Add(2*time.Hour)))
+	assert.Equal(t, []Gap{{Type: TypeGapExpired, ProductID: "BTC-USD", FirstTradeID: 5, LastTradeID: 6,
+		PrevSequence: 7, Sequence: 8, Time: t0.Add(2 * time.Hour)}}, gaps)
+	assert.True(t, fresh)
+	assert.Empty(t, s.products["BTC-USD"].gaps)
+	gaps, fresh = s.track(match(5, t0.Add(2*time.Hour)))
+	assert.Empty(t, gaps)
+	assert.False(t, fresh)
+}
+
+func TestWSClient_Feeds_should_report_gaps(t *testing.T) {
+	server, wsUrl := wsTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewClient(ctx, WithWSUrl(wsUrl))
+	if err != nil {
+		t.Fatalf("client should not be nil")
+	}
+	defer w.Close()
+
+	feeds, errFeeds := w.Feeds()
+
+	for _, m := range []Message{
+		{Type: TypeMatch, ProductID: "BTC-USD", TradeID: 1, Sequence: 1},
+		{Type: TypeMatch, ProductID: "BTC-USD", TradeID: 1, Sequence: 1},
+		{Type: TypeMatch, ProductID: "BTC-USD", TradeID: 4, Sequence: 4},
+	} {
+		assert.NoError(t, w.conn.WriteJSON(m))
+	}
+
+	// the duplicate trade is dropped, and the gap is sent before the trade revealing it
+	wantTypes := []string{TypeMatch, TypeGap, TypeMatch}
+	for _, wantType := range wantTypes {
+		select {
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for feed")
+		case event := <-feeds:
+			assert.Equal(t, wantType, event.EventType())
+			if gap, ok := event.(Gap); ok {
+				assert.Equal(t, 2, gap.FirstTradeID)
+				assert.Equal(t, 3, gap.LastTradeID)
+			}
+		case feedErr := <-errFeeds:
+			t.Fatalf("did not expect an error. got %v", feedErr)
+		}
+	}
+}
+
+func TestWSClient_Feeds_should_reconnect_stale_feed(t *testing.T) {
+	received := make(chan Message, 2)
+	server := httptest.NewServer(reconnecting(t, silenceFirst, received, matches[1]))
+	defer server.Close()
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewClient(ctx, WithWSUrl(wsUrl), WithHeartbeat(50*time.Millisecond),
+		WithBackoff(10*time.Millisecond, 10*time.Millisecond), WithSubscribeTimeout(0))
+	if err != nil {
+		t.Fatalf("client should not be nil")
+	}
+	defer w.Close()
+
+	feeds, errFeeds := w.Feeds()
+	assert.NoError(t, w.Subscribe(ChannelMatches, "BTC-USD"))
+
+	// the stale event is sent before reconnecting, and the feeds then deliver the messages of the new connection
+	wantTypes := []string{TypeStale, TypeMatch}
+	for _, wantType := range wantTypes {
+		select {
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for feed")
+		case event := <-feeds:
+			assert.Equal(t, wantType, event.EventType())
+			if stale, ok := event.(Stale); ok {
+				assert.Equal(t, "BTC-USD", stale.ProductID)
+			}
+		case feedErr := <-errFeeds:
+			t.Fatalf("did not expect an error. got %v", feedErr)
+		}
+	}
+
+	wantSubscribe := Message{Type: Subscribe, Channels: Channels{
+		NewChannel(ChannelMatches, "BTC-USD"),
+		NewChannel(ChannelHeartbeat, "BTC-USD"),
+	}}
+	assert.Equal(t, wantSubscribe, <-received)
+	assert.Equal(t, wantSubscribe, <-received)
+}
+
+func Test_watchdog(t *testing.T) {
+	t0 := time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC)
+	d := newWatchdog(3 * time.Second)
+	d.watch(t0, "BTC-USD", "ETH-USD", "ETH-BTC")
+	d.unwatch("ETH-BTC")
+
+	d.beat(t0.Add(2*time.Second), "BTC-USD")
+	assert.False(t, d.check(t0.Add(3*time.Second)))
+	assert.True(t, d.check(t0.Add(4*time.Second)))
+	assert.Equal(t, []Stale{
+		{Type: TypeStale, ProductID: "ETH-USD", LastHeartbeat: t0, Time: t0.Add(4 * time.Second)},
+	}, d.popStale())
+
+	// stale products are reported once until reset
+	assert.False(t, d.check(t0.Add(10*time.Second)))
+	assert.Empty(t, d.popStale())
+
+	d.reset(t0.Add(10 * time.Second))
+	assert.False(t, d.check(t0.Add(12*time.Second)))
+	assert.True(t, d.check(t0.Add(14*time.Second)))
+	assert.Len(t, d.popStale(), 2)
+}
+
+// unresponsive returns a handler which accepts connections, and then never reads from them, so that
+// it never answers pings nor drains the messages sent to it, until done is closed
+func unresponsive(t *testing.T, done <-chan struct{}) http.HandlerFunc {
+	t.Helper()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := websocket.Upgrader{}
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		<-done
+	}
+}
+
+func TestWSClient_Feeds_should_time_out_without_pong(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(unresponsive(t, done))
+	defer server.Close()
+	defer close(done)
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewClient(ctx, WithWSUrl(wsUrl), WithPingInterval(10*time.Millisecond),
+		WithPongWait(50*time.Millisecond), WithMaxReconnects(-1))
+	if err != nil {
+		t.Fatalf("client should not be nil")
+	}
+	defer w.Close()
+
+	_, errFeeds := w.Feeds()
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for feed error")
+	case feedErr := <-errFeeds:
+		assert.Contains(t, feedErr.Error(), "i/o timeout")
+	}
+}
+
+func TestWSClient_Feeds_should_keep_alive_with_pong(t *testing.T) {
+	server, wsUrl := wsTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewClient(ctx, WithWSUrl(wsUrl), WithPingInterval(10*time.Millisecond),
+		WithPongWait(30*time.Millisecond), WithMaxReconnects(-1))
+	if err != nil {
+		t.Fatalf("client should not be nil")
+	}
+	defer w.Close()
+
+	feeds, errFeeds := w.Feeds()
+
+	// the echo server answers pings while the feeds wait for longer than the pong wait
+	select {
+	case feedErr := <-errFeeds:
+		t.Fatalf("did not expect an error. got %v", feedErr)
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	assert.NoError(t, w.conn.WriteJSON(matches[0]))
+	select {
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for feed")
+	case event := <-feeds:
+		assert.Equal(t, matchEvents[0], event)
+	case feedErr := <-errFeeds:
+		t.Fatalf("did not expect an error. got %v", feedErr)
+	}
+}
+
+func TestWSClient_Subscribe_should_time_out(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(unresponsive(t, done))
+	defer server.Close()
+	defer close(done)
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	w, err := NewClient(context.Background(), WithWSUrl(wsUrl), WithWriteTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("client should not be nil")
+	}
+	defer w.Close()
+
+	// subscribing fills the network buffers, until the write times out
+	ids := make([]string, 100000)
+	for i := range ids {
+		ids[i] = "BTC-USD"
+	}
+	for i := 0; i < 1000; i++ {
+		if err = w.Subscribe(ChannelMatches, ids...); err != nil {
+			break
+		}
+	}
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "i/o timeout")
+	}
+}
+
+func Test_decode(t *testing.T) {
+	t0 := time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := map[string]struct {
+		msg     string
+		want    Event
+		wantErr assert.ErrorAssertionFunc
+	}{
+		"it should decode a match": {
+			msg: `{"type":"match","trade_id":10,"sequence":100,"maker_order_id":"m","taker_order_id":"t",` +
+				`"time":"2022-01-02T15:04:05Z","product_id":"ETH-BTC","size":"0.333","price":"5.0","side":"buy"}`,
+			want: Match{Type: TypeMatch, TradeID: 10, Sequence: 100, MakerOrderID: "m", TakerOrderID: "t", ProductID: "ETH-BTC",
+				Price: 5, Size: 0.333, DecimalPrice: "5.0", DecimalSize: "0.333", Side: "buy", Time: t0},
+			wantErr: assert.NoError,
+		},
+		"it should decode a last match": {
+			msg:     `{"type":"last_match","trade_id":10,"product_id":"ETH-BTC","size":"1","price":"2"}`,
+			want:    Match{Type: TypeLastMatch, TradeID: 10, ProductID: "ETH-BTC", Price: 2, Size: 1, DecimalPrice: "2", DecimalSize: "1"},
+			wantErr: assert.NoError,
+		},
+		"it should decode an error": {
+			msg:     `{"type":"error","message":"Failed to subscribe","reason":"UNKNOWN-PAIR is not a valid product"}`,
+			want:    Error{Message: "Failed to subscribe", Reason: "UNKNOWN-PAIR is not a valid product"},
+			wantErr: assert.NoError,
+		},
+		"it should decode subscriptions": {
+			msg:     `{"type":"subscriptions","channels":[{"name":"matches","product_ids":["BTC-USD","ETH-BTC"]}]}`,
+			want:    Subscriptions{Channels: Channels{NewChannel(ChannelMatches, "BTC-USD", "ETH-BTC")}},
+			wantErr: assert.NoError,
+		},
+		"it should decode a heartbeat": {
+			msg:     `{"type":"heartbeat","sequence":90,"last_trade_id":20,"product_id":"BTC-USD","time":"2022-01-02T15:04:05Z"}`,
+			want:    Heartbeat{ProductID: "BTC-USD", Sequence: 90, LastTradeID: 20, Time: t0},
+			wantErr: assert.NoError,
+		},
+		"it should ignore other types": {
+			msg:     `{"type":"received","product_id":"BTC-USD"}`,
+			wantErr: assert.NoError,
+		},
+		"it should error on invalid messages": {
+			msg: `wrong message`,
+			wantErr: func(t assert.TestingT, err error, i ...interface{}) bool {
+				return assert.Error(t, err) && assert.Contains(t, err.Error(), "unmarshal message")
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := decode([]byte(tt.msg))
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("it should decode numbers which are not numbers as NaN", func(t *testing.T) {
+		got, err := decode([]byte(`{"type":"match","product_id":"BTC-USD","size":"not-a-number","price":"1"}`))
+		assert.NoError(t, err)
+		match := got.(Match)
+		assert.Equal(t, 1.0, match.Price)
+		assert.True(t, math.IsNaN(match.Size))
+		assert.Equal(t, "not-a-number", match.DecimalSize)
+	})
+}
+
+// acknowledging returns a handler which answers every subscription like the exchange server, with the channels
+// subscribed to, or with an error naming the first invalid product of the subscription
+func acknowledging(t *testing.T, invalid ...string) http.HandlerFunc {
+	t.Helper()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := websocket.Upgrader{}
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		var subscriptions Channels
+		for {
+			msg := Message{}
+			if err := c.ReadJSON(&msg); err != nil {
+				return
+			}
+
+			answer := Message{Type: TypeSubscriptions}
+			for _, ch := range msg.Channels {
+				for _, id := range ch.ProductIDs {
+					if contains(invalid, id) && answer.Type != TypeError {
+						answer = Message{Type: TypeError, Message: "Failed to subscribe", Reason: id + " is not a valid product"}
+					}
+				}
+			}
+			if answer.Type == TypeSubscriptions {
+				for _, ch := range msg.Channels {
+					if msg.Type == Subscribe {
+						subscriptions = subscriptions.add(ch.Name, ch.ProductIDs...)
+					} else {
+						subscriptions = subscriptions.remove(ch.Name, ch.ProductIDs...)
+					}
+				}
+				answer.Channels = subscriptions
+			}
+
+			if err := c.WriteJSON(answer); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestWSClient_Subscribe_should_wait_for_acknowledgement(t *testing.T) {
+	server := httptest.NewServer(acknowledging(t, "BTC-USX"))
+	defer server.Close()
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewClient(ctx, WithWSUrl(wsUrl), WithSubscribeTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("client should not be nil")
+	}
+	defer w.Close()
+
+	feeds, errFeeds := w.Feeds()
+	go func() {
+		for range feeds {
+		}
+	}()
+
+	assert.NoError(t, w.Subscribe(ChannelMatches, "BTC-USD"))
+	assert.Equal(t, Channels{NewChannel(ChannelMatches, "BTC-USD")}, w.Subscriptions())
+
+	err = w.Subscribe(ChannelMatches, "ETH-BTC", "BTC-USX")
+	var subErr *SubscriptionError
+	if assert.True(t, errors.As(err, &subErr)) {
+		assert.Equal(t, &SubscriptionError{
+			Channel:    ChannelMatches,
+			ProductIDs: []string{"BTC-USX"},
+			Message:    "Failed to subscribe",
+			Reason:     "BTC-USX is not a valid product",
+		}, subErr)
+	}
+	assert.EqualError(t, err, "subscribe to matches: rejected products BTC-USX: Failed to subscribe: BTC-USX is not a valid product")
+
+	// a rejected subscription is not recorded
+	assert.Equal(t, Channels{NewChannel(ChannelMatches, "BTC-USD")}, w.Subscriptions())
+
+	assert.NoError(t, w.Subscribe(ChannelMatches, "ETH-BTC"))
+	assert.NoError(t, w.Unsubscribe(ChannelMatches, "BTC-USD"))
+	assert.Equal(t, Channels{NewChannel(ChannelMatches, "ETH-BTC")}, w.Subscriptions())
+
+	select {
+	case feedErr := <-errFeeds:
+		t.Fatalf("did not expect an error. got %v", feedErr)
+	default:
+	}
+}
+
+func TestWSClient_Subscribe_should_time_out_without_acknowledgement(t *testing.T) {
+	server, wsUrl := wsTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewClient(ctx, WithWSUrl(wsUrl), WithSubscribeTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("client should not be nil")
+	}
+	defer w.Close()
+
+	w.Feeds()
+
+	// the echo server only sends the subscription back
+	err = w.Subscribe(ChannelMatches, productIDs...)
+	assert.True(t, errors.Is(err, ErrSubscriptionTimeout))
+	assert.EqualError(t, err, "subscribe to matches: subscription not acknowledged within 50ms")
+	assert.Empty(t, w.Subscriptions())
+}
+
+func Test_rejected(t *testing.T) {
+	tests := map[string]struct {
+		answer Event
+		want   *SubscriptionError
+	}{
+		"it should accept the products subscribed to": {
+			answer: Subscriptions{Channels: Channels{NewChannel(ChannelMatches, "BTC-USD", "ETH-BTC", "ETH-USD")}},
+		},
+		"it should reject the products not subscribed to": {
+			answer: Subscriptions{Channels: Channels{NewChannel(ChannelHeartbeat, "BTC-USD"), NewChannel(ChannelMatches, "ETH-BTC")}},
+			want:   &SubscriptionError{Channel: ChannelMatches, ProductIDs: []string{"BTC-USD"}, Message: "not subscribed"},
+		},
+		"it should reject the products named by an error": {
+			answer: Error{Message: "Failed to subscribe", Reason: "BTC-USD is not a valid product"},
+			want: &SubscriptionError{Channel: ChannelMatches, ProductIDs: []string{"BTC-USD"}, Message: "Failed to subscribe",
+				Reason: "BTC-USD is not a valid product"},
+		},
+		"it should reject every product when an error names none": {
+			answer: Error{Message: "Failed to subscribe", Reason: "BTC-USDC is delisted"},
+			want: &SubscriptionError{Channel: ChannelMatches, ProductIDs: []string{"BTC-USD", "ETH-BTC"}, Message: "Failed to subscribe",
+				Reason: "BTC-USDC is delisted"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.want, rejected(ChannelMatches, []string{"BTC-USD", "ETH-BTC"}, tt.answer))
+		})
+	}
+}
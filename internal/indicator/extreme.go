@@ -0,0 +1,82 @@
+package indicator
+
+import (
+	"sync"
+	"time"
+	"vwap-service/internal/vwap"
+)
+
+// Extreme computes the highest or lowest price of the trades within a window
+type Extreme struct {
+	mux    *sync.Mutex
+	trades *vwap.Buffer
+
+	high    bool
+	extreme float64
+}
+
+// NewHigh creates a new Extreme computing the highest price over the given window
+func NewHigh(window Window) *Extreme {
+	return &Extreme{
+		mux:    &sync.Mutex{},
+		trades: newTrades(window),
+		high:   true,
+	}
+}
+
+// NewLow creates a new Extreme computing the lowest price over the given window
+func NewLow(window Window) *Extreme {
+	return &Extreme{
+		mux:    &sync.Mutex{},
+		trades: newTrades(window),
+	}
+}
+
+// Value returns the pre-computed highest or lowest price
+func (e *Extreme) Value() float64 {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	return e.extreme
+}
+
+// Push uses the provided price to recompute the highest or lowest price
+func (e *Extreme) Push(price float64, volume float64, t time.Time) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	first := e.trades.Len() == 0
+	evicted := e.trades.Push(price, volume, t)
+
+	// the extreme only needs to be searched again when it falls off
+	for _, ev := range evicted {
+		if ev.Price == e.extreme {
+			e.scan()
+			return nil
+		}
+	}
+
+	if first || e.better(price) {
+		e.extreme = price
+	}
+
+	return nil
+}
+
+// scan searches the extreme price among all the trades of the window
+func (e *Extreme) scan() {
+	e.extreme = e.trades.At(0).Price
+	for i := 1; i < e.trades.Len(); i++ {
+		if price := e.trades.At(i).Price; e.better(price) {
+			e.extreme = price
+		}
+	}
+}
+
+// better reports whether the price is more extreme than the current extreme
+func (e *Extreme) better(price float64) bool {
+	if e.high {
+		return price > e.extreme
+	}
+	return price < e.extreme
+}
@@ -0,0 +1,57 @@
+package indicator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExtreme_Push(t *testing.T) {
+	pushes := []push{
+		{5, 1, t0},
+		{7, 1, t0.Add(10 * time.Second)},
+		{3, 1, t0.Add(20 * time.Second)},
+		{4, 1, t0.Add(30 * time.Second)},
+		{6, 1, t0.Add(40 * time.Second)},
+	}
+
+	tests := map[string]struct {
+		extreme *Extreme
+		pushes  []push
+		want    float64
+	}{
+		"it should return the highest price": {
+			extreme: NewHigh(Window{MaxPts: 10}),
+			pushes:  pushes,
+			want:    7,
+		},
+		"it should return the lowest price": {
+			extreme: NewLow(Window{MaxPts: 10}),
+			pushes:  pushes,
+			want:    3,
+		},
+		"it should search the highest price again when it falls off": {
+			extreme: NewHigh(Window{MaxPts: 3}),
+			pushes:  pushes,
+			want:    6,
+		},
+		"it should search the lowest price again when it falls off": {
+			extreme: NewLow(Window{Duration: 15 * time.Second}),
+			pushes:  pushes,
+			want:    4,
+		},
+		"it should return the price of the only trade within the window": {
+			extreme: NewHigh(Window{Duration: time.Second}),
+			pushes:  []push{{9, 1, t0}, {2, 1, t0.Add(time.Hour)}},
+			want:    2,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			pushAll(t, tt.extreme, tt.pushes)
+
+			if got := tt.extreme.Value(); got != tt.want {
+				t.Errorf("Value() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package indicator
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"vwap-service/internal/vwap"
+)
+
+const (
+	NameVWAP = "vwap"
+	NameTWAP = "twap"
+	NameMean = "mean"
+	NameLast = "last"
+	NameHigh = "high"
+	NameLow  = "low"
+)
+
+// Indicator computes a single value from a stream of trades
+type Indicator interface {
+	Value() float64
+	Push(price float64, volume float64, t time.Time) error
+}
+
+var _ Indicator = (*vwap.VWAP)(nil)
+
+// Factory creates a new Indicator computed over the given window
+type Factory func(window Window) Indicator
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]Factory{
+		NameVWAP: func(w Window) Indicator {
+			return vwap.NewWindows([]vwap.Window{{MaxPts: w.MaxPts, Duration: w.Duration}})
+		},
+		NameTWAP: func(w Window) Indicator { return NewTWAP(w) },
+		NameMean: func(w Window) Indicator { return NewMean(w) },
+		NameLast: func(w Window) Indicator { return NewLast() },
+		NameHigh: func(w Window) Indicator { return NewHigh(w) },
+		NameLow:  func(w Window) Indicator { return NewLow(w) },
+	}
+)
+
+// Register registers the factory of an indicator under the given name, so it can be created with New
+// Registering a name twice replaces the previous factory
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	factories[name] = factory
+}
+
+// New creates a new indicator registered under the given name, computed over the given window
+func New(name string, window Window) (Indicator, error) {
+	mu.RLock()
+	factory, ok := factories[name]
+	mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown indicator %s", name)
+	}
+
+	return factory(window), nil
+}
+
+// Names returns the sorted names of the registered indicators
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
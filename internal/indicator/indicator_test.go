@@ -0,0 +1,128 @@
+package indicator
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"vwap-service/internal/vwap"
+)
+
+// push is a trade pushed into an indicator in tests
+type push struct {
+	price  float64
+	volume float64
+	time   time.Time
+}
+
+var t0 = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func pushAll(t *testing.T, ind Indicator, pushes []push) {
+	t.Helper()
+
+	for _, p := range pushes {
+		if err := ind.Push(p.price, p.volume, p.time); err != nil {
+			t.Fatalf("Push() unexpected error = %v", err)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	window := Window{MaxPts: 50}
+
+	tests := map[string]struct {
+		name    string
+		want    Indicator
+		wantErr bool
+	}{
+		"it should create a VWAP": {
+			name: NameVWAP,
+			want: vwap.New(50),
+		},
+		"it should create a TWAP": {
+			name: NameTWAP,
+			want: NewTWAP(window),
+		},
+		"it should create a Mean": {
+			name: NameMean,
+			want: NewMean(window),
+		},
+		"it should create a Last": {
+			name: NameLast,
+			want: NewLast(),
+		},
+		"it should error for an unknown indicator": {
+			name:    "unknown",
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := New(tt.name, window)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("New() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("test-last", func(w Window) Indicator { return NewLast() })
+	defer func() {
+		mu.Lock()
+		delete(factories, "test-last")
+		mu.Unlock()
+	}()
+
+	ind, err := New("test-last", Window{})
+	if err != nil {
+		t.Fatalf("New() unexpected error = %v", err)
+	}
+	if _, ok := ind.(*Last); !ok {
+		t.Errorf("New() = %T, want *Last", ind)
+	}
+
+	want := []string{NameHigh, NameLast, NameLow, NameMean, "test-last", NameTWAP, NameVWAP}
+	if got := Names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %v, want %v", got, want)
+	}
+}
+
+func TestLast_Push(t *testing.T) {
+	l := NewLast()
+	pushAll(t, l, []push{{5, 1, t0}, {4, 2, t0}, {6, 1, t0}})
+
+	if got := l.Value(); got != 6 {
+		t.Errorf("Value() = %v, want %v", got, 6)
+	}
+}
+
+func TestIndicators_concurrent_reads(t *testing.T) {
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			ind, err := New(name, Window{MaxPts: 50})
+			if err != nil {
+				t.Fatalf("New() unexpected error = %v", err)
+			}
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 2000; i++ {
+					_ = ind.Push(float64(i%100+1), 1, t0.Add(time.Duration(i)*time.Millisecond))
+				}
+			}()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					_ = ind.Value()
+				}
+			}
+		})
+	}
+}
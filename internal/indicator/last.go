@@ -0,0 +1,36 @@
+package indicator
+
+import (
+	"sync"
+	"time"
+)
+
+// Last holds the price of the last trade pushed
+type Last struct {
+	mux  *sync.Mutex
+	last float64
+}
+
+// NewLast creates a new Last
+func NewLast() *Last {
+	return &Last{
+		mux: &sync.Mutex{},
+	}
+}
+
+// Value returns the price of the last trade
+func (l *Last) Value() float64 {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	return l.last
+}
+
+// Push stores the provided price as the last one
+func (l *Last) Push(price float64, volume float64, t time.Time) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.last = price
+	return nil
+}
@@ -0,0 +1,46 @@
+package indicator
+
+import (
+	"sync"
+	"time"
+	"vwap-service/internal/vwap"
+)
+
+// Mean computes the simple average price of the trades within a window, regardless of their volume
+type Mean struct {
+	mux    *sync.Mutex
+	trades *vwap.Buffer
+
+	sumP float64
+	mean float64
+}
+
+// NewMean creates a new Mean computed over the given window
+func NewMean(window Window) *Mean {
+	return &Mean{
+		mux:    &sync.Mutex{},
+		trades: newTrades(window),
+	}
+}
+
+// Value returns the value of the pre-computed average price
+func (m *Mean) Value() float64 {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	return m.mean
+}
+
+// Push uses the provided price to recompute the average price
+func (m *Mean) Push(price float64, volume float64, t time.Time) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	for _, e := range m.trades.Push(price, volume, t) {
+		m.sumP -= e.Price
+	}
+	m.sumP += price
+	m.mean = m.sumP / float64(m.trades.Len())
+
+	return nil
+}
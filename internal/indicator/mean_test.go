@@ -0,0 +1,40 @@
+package indicator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMean_Push(t *testing.T) {
+	tests := map[string]struct {
+		window Window
+		pushes []push
+		want   float64
+	}{
+		"it should ignore volumes": {
+			window: Window{MaxPts: 10},
+			pushes: []push{{5, 100, t0}, {4, 1, t0}, {3, 1, t0}},
+			want:   4,
+		},
+		"it should only use the trades within the window": {
+			window: Window{MaxPts: 2},
+			pushes: []push{{5, 1, t0}, {4, 1, t0}, {3, 1, t0}},
+			want:   3.5,
+		},
+		"it should only use the trades within the time window": {
+			window: Window{Duration: time.Minute},
+			pushes: []push{{5, 1, t0}, {4, 1, t0.Add(30 * time.Second)}, {3, 1, t0.Add(70 * time.Second)}},
+			want:   3.5,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := NewMean(tt.window)
+			pushAll(t, m, tt.pushes)
+
+			if got := m.Value(); got != tt.want {
+				t.Errorf("Value() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package indicator
+
+import (
+	"sync"
+	"time"
+	"vwap-service/internal/vwap"
+)
+
+// TWAP computes the time-weighted average price of the trades within a window, where
+// each price is weighted by the time elapsed until the next trade. When every trade
+// of the window has the same time, it falls back to the simple average of prices
+type TWAP struct {
+	mux    *sync.Mutex
+	trades *vwap.Buffer
+
+	sumPT float64
+	sumP  float64
+	twap  float64
+}
+
+// NewTWAP creates a new TWAP computed over the given window
+func NewTWAP(window Window) *TWAP {
+	return &TWAP{
+		mux:    &sync.Mutex{},
+		trades: newTrades(window),
+	}
+}
+
+// Value returns the value of the pre-computed TWAP
+func (tw *TWAP) Value() float64 {
+	tw.mux.Lock()
+	defer tw.mux.Unlock()
+
+	return tw.twap
+}
+
+// Push uses the provided price and trade time to recompute the TWAP
+func (tw *TWAP) Push(price float64, volume float64, t time.Time) error {
+	tw.mux.Lock()
+	defer tw.mux.Unlock()
+
+	// the previous trade now holds its price until the new trade
+	if n := tw.trades.Len(); n > 0 {
+		last := tw.trades.At(n - 1)
+		tw.sumPT += last.Price * t.Sub(last.Time).Seconds()
+	}
+
+	evicted := tw.trades.Push(price, volume, t)
+	tw.sumP += price
+
+	// evicted trades no longer hold their price until the next trade
+	for i, e := range evicted {
+		next := tw.trades.At(0)
+		if i+1 < len(evicted) {
+			next = evicted[i+1]
+		}
+		tw.sumPT -= e.Price * next.Time.Sub(e.Time).Seconds()
+		tw.sumP -= e.Price
+	}
+
+	span := t.Sub(tw.trades.At(0).Time).Seconds()
+	if span > 0 {
+		tw.twap = tw.sumPT / span
+	} else {
+		tw.twap = tw.sumP / float64(tw.trades.Len())
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package indicator
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTWAP_Push(t *testing.T) {
+	tests := map[string]struct {
+		window Window
+		pushes []push
+		want   float64
+	}{
+		"it should weigh prices by the time until the next trade": {
+			window: Window{MaxPts: 10},
+			// (5*10 + 4*30) / 40
+			pushes: []push{{5, 1, t0}, {4, 1, t0.Add(10 * time.Second)}, {3, 1, t0.Add(40 * time.Second)}},
+			want:   170. / 40.,
+		},
+		"it should fall back to the average price when trades have the same time": {
+			window: Window{MaxPts: 10},
+			pushes: []push{{5, 1, t0}, {4, 1, t0}, {3, 1, t0}},
+			want:   4,
+		},
+		"it should only use the trades within the window": {
+			window: Window{MaxPts: 2},
+			// (4*30) / 30
+			pushes: []push{{5, 1, t0}, {4, 1, t0.Add(10 * time.Second)}, {3, 1, t0.Add(40 * time.Second)}},
+			want:   4,
+		},
+		"it should only use the trades within the time window": {
+			window: Window{Duration: 55 * time.Second},
+			// (4*30 + 3*20) / 50
+			pushes: []push{
+				{5, 1, t0},
+				{4, 1, t0.Add(10 * time.Second)},
+				{3, 1, t0.Add(40 * time.Second)},
+				{2, 1, t0.Add(60 * time.Second)},
+			},
+			want: 180. / 50.,
+		},
+		"it should return the price of a single trade": {
+			window: Window{Duration: time.Second},
+			pushes: []push{{5, 1, t0}, {4, 1, t0.Add(time.Hour)}},
+			want:   4,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tw := NewTWAP(tt.window)
+			pushAll(t, tw, tt.pushes)
+
+			if got := tw.Value(); math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("Value() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package indicator
+
+import (
+	"time"
+	"vwap-service/internal/vwap"
+)
+
+// Window bounds the trades an indicator is computed from, either by the Duration relative
+// to the latest trade time when set, or by the last MaxPts trades otherwise
+type Window struct {
+	MaxPts   int
+	Duration time.Duration
+}
+
+// newTrades creates the buffer of the trades within the window, which fall off like the data points of a VWAP
+func newTrades(window Window) *vwap.Buffer {
+	return vwap.NewBuffer(vwap.Window{MaxPts: window.MaxPts, Duration: window.Duration})
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"sort"
+	"time"
+)
+
+// HistoryPoint is a VWAP written for a trading pair, along with the trade time of the newest match it includes
+type HistoryPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// history is a bounded time series of the VWAPs written for a trading pair, ordered from the oldest to
+// the newest. Once full, the oldest point is overwritten, so recording a point does not allocate
+type history struct {
+	points []HistoryPoint
+	head   int
+	size   int
+}
+
+func newHistory(capacity int) *history {
+	return &history{
+		points: make([]HistoryPoint, capacity),
+	}
+}
+
+// push records a point after the newest one. Points are kept ordered by time, so a point older
+// than the newest one is recorded at the time of the newest one
+func (h *history) push(pt HistoryPoint) {
+	if h.size > 0 {
+		if newest := h.at(h.size - 1); pt.Time.Before(newest.Time) {
+			pt.Time = newest.Time
+		}
+	}
+
+	if h.size < len(h.points) {
+		h.points[(h.head+h.size)%len(h.points)] = pt
+		h.size++
+		return
+	}
+
+	h.points[h.head] = pt
+	h.head = (h.head + 1) % len(h.points)
+}
+
+// at returns the i-th oldest point
+func (h *history) at(i int) HistoryPoint {
+	return h.points[(h.head+i)%len(h.points)]
+}
+
+// search returns the number of points recorded at or before t
+func (h *history) search(t time.Time) int {
+	return sort.Search(h.size, func(i int) bool {
+		return h.at(i).Time.After(t)
+	})
+}
+
+// valueAt returns the newest point recorded at or before t, and false when there is none
+func (h *history) valueAt(t time.Time) (HistoryPoint, bool) {
+	n := h.search(t)
+	if n == 0 {
+		return HistoryPoint{}, false
+	}
+	return h.at(n - 1), true
+}
+
+// between returns the points recorded from from to to, both included
+func (h *history) between(from time.Time, to time.Time) []HistoryPoint {
+	start := sort.Search(h.size, func(i int) bool {
+		return !h.at(i).Time.Before(from)
+	})
+
+	end := h.search(to)
+
+	var pts []HistoryPoint
+	for i := start; i < end; i++ {
+		pts = append(pts, h.at(i))
+	}
+	return pts
+}
@@ -1,6 +1,10 @@
 package service
 
-import "github.com/stretchr/testify/mock"
+import (
+	"github.com/stretchr/testify/mock"
+	"time"
+	"vwap-service/internal/crypto-streamer/coinbase"
+)
 
 type VWAPMock struct {
 	mock.Mock
@@ -28,8 +32,8 @@ func (v *VWAPMock) NPoints() int {
 	return r0
 }
 
-func (v *VWAPMock) Push(price float64, volume float64) error {
-	ret := v.Called(price, volume)
+func (v *VWAPMock) Push(price float64, volume float64, t time.Time) error {
+	ret := v.Called(price, volume, t)
 
 	var r0 error
 	if rf, ok := ret.Get(0).(error); ok {
@@ -63,11 +67,11 @@ func (s *StreamerMock) Unsubscribe(channel string, productIDs ...string) error {
 	return r0
 }
 
-func (s *StreamerMock) Feeds() (feeds chan []byte, feedsErr chan error) {
+func (s *StreamerMock) Feeds() (feeds chan coinbase.Event, feedsErr chan error) {
 	ret := s.Called()
 
-	var r0 chan []byte
-	if rf, ok := ret.Get(0).(chan []byte); ok {
+	var r0 chan coinbase.Event
+	if rf, ok := ret.Get(0).(chan coinbase.Event); ok {
 		r0 = rf
 	}
 
@@ -4,12 +4,25 @@ import (
 	"go.uber.org/zap"
 	"io"
 	"os"
+	"time"
+	"vwap-service/internal/vwap"
 )
 
 type options struct {
-	logger     *zap.Logger
-	maxDataPts int
-	output     io.Writer
+	logger      *zap.Logger
+	maxDataPts  int
+	window      time.Duration
+	decimal     bool
+	windows     []vwap.Window
+	anchor      vwap.Schedule
+	halfLife    time.Duration
+	indicators  []string
+	percentiles []float64
+	outliers    OutlierFilter
+	historySize int
+	output      io.Writer
+	barOutput   io.Writer
+	intervals   []time.Duration
 }
 
 type Option interface {
@@ -46,6 +59,147 @@ func WithMaxDataPts(maxDataPts int) Option {
 	return maxDataPtsOption{DataPoints: maxDataPts}
 }
 
+type windowOption struct {
+	Window time.Duration
+}
+
+func (w windowOption) apply(opts *options) {
+	opts.window = w.Window
+}
+
+// WithWindow computes VWAPs over the trades executed within the given duration
+// instead of over the last maxDataPts trades. A window of 0 keeps the data points window
+func WithWindow(window time.Duration) Option {
+	if window < 0 {
+		window = 0
+	}
+	return windowOption{Window: window}
+}
+
+type decimalOption struct {
+	Decimal bool
+}
+
+func (d decimalOption) apply(opts *options) {
+	opts.decimal = d.Decimal
+}
+
+// WithDecimal computes VWAPs with exact decimal arithmetic from the prices and sizes sent
+// by the exchange, rather than with float64 arithmetic
+func WithDecimal(decimal bool) Option {
+	return decimalOption{Decimal: decimal}
+}
+
+type windowsOption struct {
+	Windows []vwap.Window
+}
+
+func (w windowsOption) apply(opts *options) {
+	opts.windows = w.Windows
+}
+
+// WithWindows computes a VWAP for each of the given named windows for every trading pair,
+// all sharing the same list of trades. Windows take precedence over WithMaxDataPts,
+// WithWindow and WithDecimal
+func WithWindows(windows ...vwap.Window) Option {
+	return windowsOption{Windows: windows}
+}
+
+type anchorOption struct {
+	Schedule vwap.Schedule
+}
+
+func (a anchorOption) apply(opts *options) {
+	opts.anchor = a.Schedule
+}
+
+// WithAnchor computes anchored VWAPs for every trading pair, which grow from the start of
+// each session of the given schedule until the next one, instead of rolling VWAPs.
+// Anchored VWAPs take precedence over every other VWAP option
+func WithAnchor(schedule vwap.Schedule) Option {
+	return anchorOption{Schedule: schedule}
+}
+
+type halfLifeOption struct {
+	HalfLife time.Duration
+}
+
+func (h halfLifeOption) apply(opts *options) {
+	opts.halfLife = h.HalfLife
+}
+
+// WithHalfLife computes exponentially time-decayed VWAPs with the given half-life for every
+// trading pair, instead of rolling VWAPs. A half-life of 0 keeps rolling VWAPs.
+// Decayed VWAPs take precedence over every other VWAP option but WithAnchor
+func WithHalfLife(halfLife time.Duration) Option {
+	if halfLife < 0 {
+		halfLife = 0
+	}
+	return halfLifeOption{HalfLife: halfLife}
+}
+
+type indicatorsOption struct {
+	Names []string
+}
+
+func (i indicatorsOption) apply(opts *options) {
+	opts.indicators = i.Names
+}
+
+// WithIndicators computes the given indicators next to the VWAP of every trading pair, over the
+// same window, the first one of named windows. Available indicators are vwap, twap, mean, last, high, low,
+// and any indicator registered with indicator.Register. Indicators are not computed for anchored or decayed
+// VWAPs, nor for volume windows
+func WithIndicators(names ...string) Option {
+	return indicatorsOption{Names: names}
+}
+
+type percentilesOption struct {
+	Percentiles []float64
+}
+
+func (p percentilesOption) apply(opts *options) {
+	opts.percentiles = p.Percentiles
+}
+
+// WithPercentiles computes the volume-weighted median of the prices of every rolling VWAP window,
+// along with the given percentiles between 0 and 100, and writes them next to the VWAP.
+// Nothing is computed when no percentile is provided, WithPercentiles(50) only computes the median
+func WithPercentiles(ps ...float64) Option {
+	return percentilesOption{Percentiles: ps}
+}
+
+type outlierFilterOption struct {
+	Filter OutlierFilter
+}
+
+func (o outlierFilterOption) apply(opts *options) {
+	opts.outliers = o.Filter
+}
+
+// WithOutlierFilter rejects the trades deviating too much from the current VWAP or the last price
+// of their trading pair before they update its VWAP (see OutlierFilter)
+func WithOutlierFilter(filter OutlierFilter) Option {
+	return outlierFilterOption{Filter: filter}
+}
+
+type historyOption struct {
+	Size int
+}
+
+func (h historyOption) apply(opts *options) {
+	opts.historySize = h.Size
+}
+
+// WithHistory keeps the last size VWAPs written for every trading pair in memory, so they can be
+// queried with ValueAt and History. A size of 0 keeps none
+func WithHistory(size int) Option {
+	if size < 0 {
+		size = 0
+	}
+	return historyOption{Size: size}
+}
+
 type outputOption struct {
 	output io.Writer
 }
@@ -60,3 +214,113 @@ func WithOutput(output io.Writer) Option {
 	}
 	return outputOption{output: output}
 }
+
+type barsOption struct {
+	Output    io.Writer
+	Intervals []time.Duration
+}
+
+func (b barsOption) apply(opts *options) {
+	opts.barOutput = b.Output
+	opts.intervals = b.Intervals
+}
+
+// WithBars builds time-aligned OHLCV bars of the given intervals for every trading pair, and writes
+// them to the given output once they are completed. Bars are built for 1s, 1m, 5m and 1h intervals
+// when none are provided, and are not built when output is nil
+func WithBars(output io.Writer, intervals ...time.Duration) Option {
+	return barsOption{Output: output, Intervals: intervals}
+}
+
+type pairOptions struct {
+	windows    []vwap.Window
+	anchor     vwap.Schedule
+	halfLife   time.Duration
+	volume     float64
+	indicators []string
+}
+
+// hasMode returns whether the windows, anchor, half-life or volume of the VWAP are set
+func (o pairOptions) hasMode() bool {
+	return len(o.windows) > 0 || o.anchor != nil || o.halfLife > 0 || o.volume > 0
+}
+
+type PairOption interface {
+	apply(*pairOptions)
+}
+
+type pairWindowsOption struct {
+	Windows []vwap.Window
+}
+
+func (w pairWindowsOption) apply(opts *pairOptions) {
+	opts.windows = w.Windows
+}
+
+// WithPairWindows computes a VWAP for each of the given named windows for a single
+// trading pair, instead of the service's windows
+func WithPairWindows(windows ...vwap.Window) PairOption {
+	return pairWindowsOption{Windows: windows}
+}
+
+type pairAnchorOption struct {
+	Schedule vwap.Schedule
+}
+
+func (a pairAnchorOption) apply(opts *pairOptions) {
+	opts.anchor = a.Schedule
+}
+
+// WithPairAnchor computes an anchored VWAP for a single trading pair, which grows from the
+// start of each session of the given schedule until the next one
+func WithPairAnchor(schedule vwap.Schedule) PairOption {
+	return pairAnchorOption{Schedule: schedule}
+}
+
+type pairHalfLifeOption struct {
+	HalfLife time.Duration
+}
+
+func (h pairHalfLifeOption) apply(opts *pairOptions) {
+	opts.halfLife = h.HalfLife
+}
+
+// WithPairHalfLife computes an exponentially time-decayed VWAP with the given half-life
+// for a single trading pair
+func WithPairHalfLife(halfLife time.Duration) PairOption {
+	if halfLife < 0 {
+		halfLife = 0
+	}
+	return pairHalfLifeOption{HalfLife: halfLife}
+}
+
+type pairVolumeOption struct {
+	Volume float64
+}
+
+func (v pairVolumeOption) apply(opts *pairOptions) {
+	opts.volume = v.Volume
+}
+
+// WithPairVolume computes the VWAP of the last given volume traded for a single trading pair,
+// the oldest trade being partially counted so that the VWAP covers exactly the given volume
+func WithPairVolume(volume float64) PairOption {
+	if !(volume > 0) {
+		volume = 0
+	}
+	return pairVolumeOption{Volume: volume}
+}
+
+type pairIndicatorsOption struct {
+	Names []string
+}
+
+func (i pairIndicatorsOption) apply(opts *pairOptions) {
+	opts.indicators = i.Names
+}
+
+// WithPairIndicators computes the given indicators for a single trading pair, instead of
+// the service's indicators
+func WithPairIndicators(names ...string) PairOption {
+	return pairIndicatorsOption{Names: names}
+}
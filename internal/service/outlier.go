@@ -0,0 +1,141 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+	"vwap-service/internal/crypto-streamer/coinbase"
+)
+
+// ErrOutlier is returned when a trade deviates too much from the reference price of its trading pair
+var ErrOutlier = errors.New("outlier trade")
+
+// OutlierReference is the price of a trading pair which trades are compared to by an OutlierFilter
+type OutlierReference int
+
+const (
+	// ReferenceVWAP compares trades to the current VWAP of their trading pair
+	ReferenceVWAP OutlierReference = iota
+	// ReferenceLast compares trades to the last price which passed the filter
+	ReferenceLast
+)
+
+// _defaultMaxRejections is the number of consecutive outliers after which the price of a trading pair
+// is considered to have shifted
+const _defaultMaxRejections = 10
+
+// OutlierFilter rejects the trades whose price deviates more than MaxPercent percent, or more than
+// MaxStdDevs volume-weighted standard deviations of the VWAP, from the reference price of their trading pair,
+// before they update the VWAP. A zero bound is not checked, and trades are not filtered until the trading pair
+// has a reference price. Rejected trades are also written to Output when set.
+// Once MaxRejections consecutive trades of a trading pair were rejected, 10 when 0, its price is considered
+// to have shifted: the next trade is accepted, and trades are compared to the last price until the VWAP
+// is within bounds of it again. A negative MaxRejections never accepts outliers
+type OutlierFilter struct {
+	Reference     OutlierReference
+	MaxPercent    float64
+	MaxStdDevs    float64
+	MaxRejections int
+	Output        io.Writer
+}
+
+// enabled returns whether the filter checks any bound
+func (f OutlierFilter) enabled() bool {
+	return f.MaxPercent > 0 || f.MaxStdDevs > 0
+}
+
+// maxRejections returns the number of consecutive outliers after which the price is considered to have shifted,
+// or 0 when it never is
+func (f OutlierFilter) maxRejections() int {
+	switch {
+	case f.MaxRejections < 0:
+		return 0
+	case f.MaxRejections == 0:
+		return _defaultMaxRejections
+	default:
+		return f.MaxRejections
+	}
+}
+
+// checkOutlier returns an error wrapping ErrOutlier when the price of the match deviates too much from
+// the reference price of the trading pair, and records the price as the last one otherwise.
+// Matches whose price is invalid are left to updateVWAP
+func (v *vwapRecord) checkOutlier(f OutlierFilter, m coinbase.Match) error {
+	price := m.Price
+	if !(price > 0) || math.IsInf(price, 0) {
+		return nil
+	}
+
+	reference, ok := v.outlierReference(f)
+	if !ok {
+		v.lastPrice = price
+		return nil
+	}
+
+	if err := v.deviation(f, price, reference); err != nil {
+		if limit := f.maxRejections(); limit == 0 || v.outliers < limit {
+			v.outliers++
+			return err
+		}
+
+		// the price shifted, so the VWAP is no longer a reference until it catches up with it
+		v.shifted = f.Reference != ReferenceLast
+	}
+
+	v.outliers = 0
+	v.lastPrice = price
+	return nil
+}
+
+// deviation returns an error wrapping ErrOutlier when the price deviates too much from the reference price
+func (v *vwapRecord) deviation(f OutlierFilter, price float64, reference float64) error {
+	deviation := math.Abs(price - reference)
+	if f.MaxPercent > 0 && deviation > reference*f.MaxPercent/100 {
+		return fmt.Errorf("%w: price %s deviates %s%% from %s", ErrOutlier, formatNumber(price),
+			strconv.FormatFloat(deviation/reference*100, 'f', 2, 64), formatFloat(reference))
+	}
+
+	if d, isDeviationer := v.VWaper.(Deviationer); isDeviationer && f.MaxStdDevs > 0 {
+		if stdDev := d.StdDev(); stdDev > 0 && deviation > stdDev*f.MaxStdDevs {
+			return fmt.Errorf("%w: price %s deviates %s standard deviations from %s", ErrOutlier, formatNumber(price),
+				strconv.FormatFloat(deviation/stdDev, 'f', 2, 64), formatFloat(reference))
+		}
+	}
+
+	return nil
+}
+
+// outlierReference returns the reference price of the trading pair, and false when it does not have one yet.
+// After a price shift, the last price is the reference until the VWAP is within bounds of it again
+func (v *vwapRecord) outlierReference(f OutlierFilter) (float64, bool) {
+	if f.Reference == ReferenceLast {
+		return v.lastPrice, v.lastPrice > 0
+	}
+
+	if v.NPoints() == 0 {
+		return 0, false
+	}
+	value := v.Value()
+	if v.shifted {
+		if !(value > 0) || v.deviation(f, value, v.lastPrice) != nil {
+			return v.lastPrice, true
+		}
+		v.shifted = false
+	}
+	return value, value > 0
+}
+
+// formatOutlier returns a trade rejected as an outlier, e.g.
+// BTC-USD 2022-01-02T15:04:05Z price: 1 size: 2 error: outlier trade: price 1 deviates 50.00% from 2.000000
+func formatOutlier(m coinbase.Match, err error) string {
+	return m.ProductID + " " + m.Time.UTC().Format(time.RFC3339Nano) +
+		" price: " + formatNumber(m.Price) + " size: " + formatNumber(m.Size) + " error: " + err.Error()
+}
+
+// formatNumber returns the shortest format of a number sent by the exchange, e.g. 0.1 instead of 0.100000
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
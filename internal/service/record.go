@@ -0,0 +1,356 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"vwap-service/internal/bar"
+	"vwap-service/internal/crypto-streamer/coinbase"
+	"vwap-service/internal/indicator"
+	"vwap-service/internal/vwap"
+)
+
+type vwapRecord struct {
+	VWaper
+	Name       string
+	Indicators []namedIndicator
+	Bars       *bar.Aggregator
+	History    *history
+
+	// completedBars are the bars completed by the pushed trades, until they are written
+	completedBars []bar.Bar
+	// lastPrice is the last price which passed the outlier filter
+	lastPrice float64
+	// outliers is the number of consecutive trades rejected by the outlier filter
+	outliers int
+	// shifted is set once the price shifted away from the VWAP, until the VWAP catches up with it
+	shifted bool
+	// gaps are the gaps in the trades of the trading pair which may still fall within its VWAP
+	gaps []tradeGap
+}
+
+// tradeGap is a range of missed trades, detected at the trade time of the message which revealed it
+type tradeGap struct {
+	first int
+	last  int
+	time  time.Time
+}
+
+// degraded returns whether the VWAP may be missing trades
+func (v *vwapRecord) degraded() bool {
+	return len(v.gaps) > 0
+}
+
+// addGap records a range of missed trades
+func (v *vwapRecord) addGap(first int, last int, t time.Time) {
+	v.gaps = append(v.gaps, tradeGap{first: first, last: last, time: t})
+}
+
+// recoverGap forgets a range of missed trades once they were all received
+func (v *vwapRecord) recoverGap(first int, last int) {
+	kept := v.gaps[:0]
+	for _, g := range v.gaps {
+		if g.first != first || g.last != last {
+			kept = append(kept, g)
+		}
+	}
+	v.gaps = kept
+}
+
+// rollOver forgets the gaps which no longer fall within the VWAP, once the oldest trade of all its windows,
+// or its anchor, is more recent than them. Other VWAPs stay degraded until their gaps are recovered or expire
+func (v *vwapRecord) rollOver() {
+	if len(v.gaps) == 0 {
+		return
+	}
+
+	var start time.Time
+	switch vw := v.VWaper.(type) {
+	case Oldester:
+		start = vw.Oldest()
+		if start.IsZero() {
+			return
+		}
+	case Anchorer:
+		start = vw.Anchor()
+	default:
+		return
+	}
+
+	kept := v.gaps[:0]
+	for _, g := range v.gaps {
+		if !start.After(g.time) {
+			kept = append(kept, g)
+		}
+	}
+	v.gaps = kept
+}
+
+// namedIndicator is an indicator computed for a trading pair alongside its VWAP
+type namedIndicator struct {
+	indicator.Indicator
+	Name string
+}
+
+func (v *vwapRecord) updateVWAP(m coinbase.Match) error {
+	// decimal VWAPs are fed the exchange values as is, so they are never rounded
+	dv, isDecimal := v.VWaper.(DecimalVWaper)
+	if isDecimal {
+		if err := dv.PushDecimal(m.DecimalPrice, m.DecimalSize, m.Time); err != nil {
+			return fmt.Errorf("push trading-pair to VWAP: %w", err)
+		}
+		if len(v.Indicators) == 0 && v.Bars == nil {
+			return nil
+		}
+	}
+
+	trade, err := matchTrade(m)
+	if err != nil {
+		return err
+	}
+
+	if !isDecimal {
+		push := v.Push
+		if s, ok := v.VWaper.(SideSplitter); ok {
+			push = func(price float64, volume float64, t time.Time) error {
+				return s.PushSide(price, volume, t, trade.Side)
+			}
+		}
+
+		if err := push(trade.Price, trade.Volume, trade.Time); err != nil {
+			return fmt.Errorf("push trading-pair to VWAP: %w", err)
+		}
+	}
+
+	return v.pushDerived(trade)
+}
+
+// updateVWAPBatch updates the VWAP with the given matches in order, in a single batch when the VWAP
+// supports it, and returns the error of every match, which is nil for the pushed ones
+func (v *vwapRecord) updateVWAPBatch(matches []coinbase.Match) []error {
+	errs := make([]error, len(matches))
+
+	bp, ok := v.VWaper.(BatchPusher)
+	if !ok || len(matches) == 1 {
+		for i, m := range matches {
+			errs[i] = v.updateVWAP(m)
+		}
+		return errs
+	}
+
+	// matches which are not numbers are left out of the batch, so we keep the index of the match of every trade
+	trades := make([]vwap.Trade, 0, len(matches))
+	indices := make([]int, 0, len(matches))
+	for i, m := range matches {
+		trade, err := matchTrade(m)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		trades = append(trades, trade)
+		indices = append(indices, i)
+	}
+
+	if err := bp.PushBatch(trades); err != nil {
+		var batchErr *vwap.BatchError
+		if !errors.As(err, &batchErr) {
+			for _, i := range indices {
+				errs[i] = fmt.Errorf("push trading-pair to VWAP: %w", err)
+			}
+			return errs
+		}
+
+		for _, tErr := range batchErr.Errs {
+			errs[indices[tErr.Index]] = fmt.Errorf("push trading-pair to VWAP: %w", tErr.Err)
+		}
+	}
+
+	for j, trade := range trades {
+		if i := indices[j]; errs[i] == nil {
+			errs[i] = v.pushDerived(trade)
+		}
+	}
+
+	return errs
+}
+
+// pushDerived pushes a trade pushed to the VWAP to every indicator of the trading pair,
+// and to its bars
+func (v *vwapRecord) pushDerived(trade vwap.Trade) error {
+	for _, ind := range v.Indicators {
+		if err := ind.Push(trade.Price, trade.Volume, trade.Time); err != nil {
+			return fmt.Errorf("push trading-pair to %s indicator: %w", ind.Name, err)
+		}
+	}
+
+	if v.Bars != nil {
+		v.completedBars = append(v.completedBars, v.Bars.Push(trade.Price, trade.Volume, trade.Time, trade.Side)...)
+	}
+
+	return nil
+}
+
+// popBars returns the bars completed since the last call
+func (v *vwapRecord) popBars() []bar.Bar {
+	bars := v.completedBars
+	v.completedBars = nil
+	return bars
+}
+
+// matchTrade returns the trade of a match sent by the exchange, or an error when its price or size
+// is not a number
+func matchTrade(m coinbase.Match) (vwap.Trade, error) {
+	if math.IsNaN(m.Price) {
+		return vwap.Trade{}, fmt.Errorf("%w '%s'", vwap.ErrInvalidPrice, m.DecimalPrice)
+	}
+	if math.IsNaN(m.Size) {
+		return vwap.Trade{}, fmt.Errorf("%w '%s'", vwap.ErrInvalidVolume, m.DecimalSize)
+	}
+
+	return vwap.Trade{Price: m.Price, Volume: m.Size, Time: m.Time, Side: aggressorSide(m.Side)}, nil
+}
+
+// aggressorSide returns the side of the aggressor of a match from the side of its maker order,
+// as a match with a sell maker order is a buy from the taker and vice versa
+func aggressorSide(makerSide string) vwap.Side {
+	switch makerSide {
+	case "sell":
+		return vwap.SideBuy
+	case "buy":
+		return vwap.SideSell
+	default:
+		return vwap.SideUnknown
+	}
+}
+
+// string returns the VWAP of the trading pair, or one line per VWAP tagged with
+// the window name when computed for named windows. VWAPs are followed by their
+// standard deviation and ±1/±2 standard deviation bands, and by the VWAP and volume
+// of the buy and sell aggressors when available, and by the volume-weighted median and
+// percentiles of the prices when computed. The values of the indicators of
+// the trading pair follow the first VWAP. VWAPs which may be missing trades are flagged as degraded
+func (v vwapRecord) string() string {
+	lines := v.vwapLines()
+	lines[0] += formatIndicators(v.Indicators)
+	if v.degraded() {
+		for i := range lines {
+			lines[i] += " degraded: true"
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (v vwapRecord) vwapLines() []string {
+	_, split := v.VWaper.(SideSplitter)
+
+	if w, ok := v.VWaper.(Windower); ok {
+		var lines []string
+		for _, wv := range w.Windows() {
+			name := v.Name
+			if wv.Name != "" {
+				name += "[" + wv.Name + "]"
+			}
+
+			line := name + ": " + formatFloat(wv.Value) + formatBands(wv.StdDev, wv.Bands)
+			if split {
+				line += formatSides(wv.Buy, wv.Sell)
+			}
+			line += formatPercentiles(wv.Percentiles)
+			lines = append(lines, line)
+		}
+		return lines
+	}
+
+	if dv, ok := v.VWaper.(DecimalVWaper); ok {
+		return []string{v.Name + ": " + dv.DecimalValue(6)}
+	}
+
+	line := v.Name + ": " + formatFloat(v.Value())
+	if d, ok := v.VWaper.(Deviationer); ok {
+		line += formatBands(d.StdDev(), d.Bands)
+	}
+	if s, ok := v.VWaper.(SideSplitter); ok {
+		line += formatSides(s.Side(vwap.SideBuy), s.Side(vwap.SideSell))
+	}
+	return []string{line}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 6, 64)
+}
+
+// formatBar returns a completed bar of the trading pair tagged with its interval, e.g.
+// BTC-USD[1m] 2022-01-02T15:04:00Z open: 1 high: 2 low: 1 close: 2 volume: 3 vwap: 1.5 trades: 2 buy-volume: 1 sell-volume: 2
+func formatBar(name string, b bar.Bar) string {
+	return name + "[" + formatInterval(b.Interval) + "] " + b.Start.UTC().Format(time.RFC3339) +
+		" open: " + formatFloat(b.Open) +
+		" high: " + formatFloat(b.High) +
+		" low: " + formatFloat(b.Low) +
+		" close: " + formatFloat(b.Close) +
+		" volume: " + formatFloat(b.Volume) +
+		" vwap: " + formatFloat(b.VWAP) +
+		" trades: " + strconv.Itoa(b.Trades) +
+		" buy-volume: " + formatFloat(b.BuyVolume) +
+		" sell-volume: " + formatFloat(b.SellVolume)
+}
+
+// formatInterval returns the shortest format of an interval, e.g. 1m instead of 1m0s
+func formatInterval(interval time.Duration) string {
+	s := interval.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+func formatIndicators(indicators []namedIndicator) string {
+	out := ""
+	for _, ind := range indicators {
+		out += " " + ind.Name + ": " + formatFloat(ind.Value())
+	}
+	return out
+}
+
+// formatPercentiles returns the percentiles of a window, e.g. median: 2 p5: 1 p95: 3
+func formatPercentiles(percentiles []vwap.Percentile) string {
+	out := ""
+	for _, p := range percentiles {
+		label := "p" + strconv.FormatFloat(p.P, 'f', -1, 64)
+		if p.P == 50 {
+			label = "median"
+		}
+		out += " " + label + ": " + formatFloat(p.Value)
+	}
+	return out
+}
+
+func formatSides(buy vwap.SideValue, sell vwap.SideValue) string {
+	return " buy: " + formatFloat(buy.Value) + " buy-volume: " + formatFloat(buy.Volume) +
+		" sell: " + formatFloat(sell.Value) + " sell-volume: " + formatFloat(sell.Volume)
+}
+
+func formatBands(stdDev float64, bands func(k float64) (float64, float64)) string {
+	lower1, upper1 := bands(1)
+	lower2, upper2 := bands(2)
+
+	return " sd: " + formatFloat(stdDev) +
+		" -2sd: " + formatFloat(lower2) + " -1sd: " + formatFloat(lower1) +
+		" +1sd: " + formatFloat(upper1) + " +2sd: " + formatFloat(upper2)
+}
+
+type vwapRecords map[string]*vwapRecord
+
+func (v vwapRecords) tradingPairs() []string {
+	var pairs []string
+	for tp := range v {
+		pairs = append(pairs, tp)
+	}
+	return pairs
+}
@@ -2,37 +2,55 @@ package service
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
 	"io"
 	"os"
-	"strconv"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+	"vwap-service/internal/bar"
 	"vwap-service/internal/crypto-streamer/coinbase"
+	"vwap-service/internal/indicator"
 	"vwap-service/internal/vwap"
 )
 
 const (
 	_defaultMaxPts = 200
+	_maxBatchSize  = 100
 )
 
 // Service is a calculattion engine service used to compute VWAP's for given trading-pairs,
 // and output them to a target output streamer is a crypto exchange streamer that implements the Streamer interface
-// Available options are WithLogger(logger), WithOutput(output = stdout), WithMaxDataPts(max = 200)
+// Available options are WithLogger(logger), WithOutput(output = stdout), WithMaxDataPts(max = 200),
+// WithWindow(window = 0), WithDecimal(decimal = false), WithWindows(windows...),
+// WithAnchor(schedule = nil), WithHalfLife(halfLife = 0), WithIndicators(names...), WithPercentiles(ps...),
+// WithOutlierFilter(filter), WithHistory(size = 0), WithBars(output = nil, intervals...)
 type Service struct {
-	mu         sync.Mutex
-	ctx        context.Context
-	vwaps      vwapRecords
-	logger     *zap.Logger
-	streamer   Streamer
-	maxDataPts int
-	output     io.Writer
-	stop       chan bool
-	running    *atomic.Bool
+	mu          sync.Mutex
+	ctx         context.Context
+	vwaps       vwapRecords
+	logger      *zap.Logger
+	streamer    Streamer
+	maxDataPts  int
+	window      time.Duration
+	decimal     bool
+	windows     []vwap.Window
+	anchor      vwap.Schedule
+	halfLife    time.Duration
+	indicators  []string
+	percentiles []float64
+	outliers    OutlierFilter
+	historySize int
+	rejections  Rejections
+	output      io.Writer
+	barOutput   io.Writer
+	intervals   []time.Duration
+	stop        chan bool
+	running     *atomic.Bool
 }
 
 // NewService creates a new calculation engine service
@@ -48,14 +66,25 @@ func NewService(ctx context.Context, streamer Streamer, opts ...Option) *Service
 	}
 
 	return &Service{
-		streamer:   streamer,
-		ctx:        ctx,
-		vwaps:      make(vwapRecords),
-		logger:     options.logger,
-		maxDataPts: options.maxDataPts,
-		output:     options.output,
-		stop:       make(chan bool, 1),
-		running:    atomic.NewBool(false),
+		streamer:    streamer,
+		ctx:         ctx,
+		vwaps:       make(vwapRecords),
+		logger:      options.logger,
+		maxDataPts:  options.maxDataPts,
+		window:      options.window,
+		decimal:     options.decimal,
+		windows:     options.windows,
+		anchor:      options.anchor,
+		halfLife:    options.halfLife,
+		indicators:  options.indicators,
+		percentiles: options.percentiles,
+		outliers:    options.outliers,
+		historySize: options.historySize,
+		output:      options.output,
+		barOutput:   options.barOutput,
+		intervals:   options.intervals,
+		stop:        make(chan bool, 1),
+		running:     atomic.NewBool(false),
 	}
 }
 
@@ -67,15 +96,166 @@ func (s *Service) AddTradingPairs(tradingPairs ...string) {
 	defer s.mu.Unlock()
 
 	for _, tp := range tradingPairs {
-		tp = strings.ToUpper(tp)
+		s.addTradingPair(tp, s.defaultPairOptions())
+	}
+}
 
-		if _, ok := s.vwaps[tp]; !ok {
-			s.vwaps[tp] = &vwapRecord{
-				VWaper: vwap.New(s.maxDataPts),
-				Name:   tp,
-			}
+// AddTradingPair creates a new vwap record for the given trading pair, configured with the
+// given pair options instead of the service's options. Available options are WithPairWindows(windows...),
+// WithPairAnchor(schedule), WithPairHalfLife(halfLife), WithPairVolume(volume), WithPairIndicators(names...)
+// When any of the windows, anchor, half-life or volume of the pair is set, none of the service's is used.
+// Trading pairs must be added before the Run method is executed.
+func (s *Service) AddTradingPair(tradingPair string, opts ...PairOption) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	defaults := s.defaultPairOptions()
+	options := pairOptions{indicators: defaults.indicators}
+	for _, o := range opts {
+		o.apply(&options)
+	}
+	if !options.hasMode() {
+		options.windows = defaults.windows
+		options.anchor = defaults.anchor
+		options.halfLife = defaults.halfLife
+	}
+
+	s.addTradingPair(tradingPair, options)
+}
+
+func (s *Service) addTradingPair(tp string, options pairOptions) {
+	tp = strings.ToUpper(tp)
+
+	if _, ok := s.vwaps[tp]; !ok {
+		record := &vwapRecord{
+			VWaper:     s.newVWAP(options),
+			Name:       tp,
+			Indicators: s.newIndicators(options),
+		}
+		if s.barOutput != nil {
+			record.Bars = bar.NewAggregator(s.intervals...)
+		}
+		if s.historySize > 0 {
+			record.History = newHistory(s.historySize)
+		}
+		s.vwaps[tp] = record
+	}
+}
+
+// newIndicators creates the named indicators of a single trading pair, computed over the window of its VWAP
+// Unknown indicators are logged and skipped, and so are all of them when the window is not supported
+func (s *Service) newIndicators(options pairOptions) []namedIndicator {
+	if len(options.indicators) == 0 {
+		return nil
+	}
+
+	window, err := s.indicatorWindow(options)
+	if err != nil {
+		s.logger.Error("failed to create indicators", zap.Strings("indicators", options.indicators),
+			zap.NamedError("error", err))
+		return nil
+	}
+
+	var indicators []namedIndicator
+	for _, name := range options.indicators {
+		ind, err := indicator.New(name, window)
+		if err != nil {
+			s.logger.Error("failed to create indicator", zap.NamedError("error", err))
+			continue
+		}
+		indicators = append(indicators, namedIndicator{Indicator: ind, Name: name})
+	}
+
+	return indicators
+}
+
+// indicatorWindow returns the window of the VWAP of a trading pair, with the same precedence as newVWAP,
+// or an error when indicators cannot cover it: only windows bounded by a number of trades or by a duration are
+func (s *Service) indicatorWindow(options pairOptions) (indicator.Window, error) {
+	switch {
+	case options.anchor != nil:
+		return indicator.Window{}, errors.New("indicators do not support anchored VWAPs")
+	case options.halfLife > 0:
+		return indicator.Window{}, errors.New("indicators do not support decayed VWAPs")
+	case len(options.windows) > 0:
+		w := options.windows[0]
+		if w.Duration <= 0 && w.Volume > 0 {
+			return indicator.Window{}, errors.New("indicators do not support volume windows")
+		}
+		return indicator.Window{MaxPts: w.MaxPts, Duration: w.Duration}, nil
+	case options.volume > 0:
+		return indicator.Window{}, errors.New("indicators do not support volume windows")
+	default:
+		return indicator.Window{MaxPts: s.maxDataPts, Duration: s.window}, nil
+	}
+}
+
+// defaultPairOptions returns the pair options derived from the service's options
+func (s *Service) defaultPairOptions() pairOptions {
+	return pairOptions{
+		windows:    s.windows,
+		anchor:     s.anchor,
+		halfLife:   s.halfLife,
+		indicators: s.indicators,
+	}
+}
+
+// Reanchor resets the anchored VWAP of the given trading pair so that it is computed from the given instant
+func (s *Service) Reanchor(tradingPair string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tpvwap, ok := s.vwaps[strings.ToUpper(tradingPair)]
+	if !ok {
+		return fmt.Errorf("reanchor: unknown trading pair %s", tradingPair)
+	}
+
+	anchorer, ok := tpvwap.VWaper.(Anchorer)
+	if !ok {
+		return fmt.Errorf("reanchor: trading pair %s does not compute an anchored VWAP", tradingPair)
+	}
+
+	anchorer.Reanchor(at)
+	return nil
+}
+
+// newVWAP creates the VWaper used for a single trading pair, anchored to the pair's schedule when set,
+// decayed with the pair's half-life when set, computing the pair's named windows when set, or bounded
+// by the pair's volume when set. Otherwise, it is bounded by the service's time window when set, or by
+// its max number of data points. Rolling float64 VWAPs also compute the service's percentiles when set
+func (s *Service) newVWAP(options pairOptions) VWaper {
+	var opts []vwap.Option
+	if len(s.percentiles) > 0 {
+		opts = append(opts, vwap.WithPercentiles(s.percentiles...))
+	}
+
+	if options.anchor != nil {
+		return vwap.NewAnchored(options.anchor)
+	}
+
+	if options.halfLife > 0 {
+		return vwap.NewDecayed(options.halfLife)
+	}
+
+	if len(options.windows) > 0 {
+		return vwap.NewWindows(options.windows, opts...)
+	}
+
+	if options.volume > 0 {
+		return vwap.NewVolumeWindow(options.volume, opts...)
+	}
+
+	if s.decimal {
+		if s.window > 0 {
+			return vwap.NewDecimalTimeWindow(s.window)
 		}
+		return vwap.NewDecimal(s.maxDataPts)
+	}
+
+	if s.window > 0 {
+		return vwap.NewTimeWindow(s.window, opts...)
 	}
+	return vwap.New(s.maxDataPts, opts...)
 }
 
 // Run reads Streamer feeds and computes the VWAP for returned trading pairs
@@ -91,21 +271,22 @@ func (s *Service) Run() error {
 		return errors.New("no trading pairs were provided")
 	}
 
-	// subscribing to the streamer's channel for the available trading pairs
-	if err := s.streamer.Subscribe(coinbase.ChannelMatches, s.vwaps.tradingPairs()...); err != nil {
-		return fmt.Errorf("subscribe to matches channel: %w", err)
-	}
-
-	// retrieve trading-pair matches from exchange server
+	// retrieve trading-pair matches from exchange server, which are read while subscribing
+	// so that the exchange can acknowledge or reject the subscription after sending other messages
 	feeds, feedsErr := s.streamer.Feeds()
-	if err := s.handleFeeds(feeds, feedsErr); err != nil {
-		return fmt.Errorf("handle feeds: %w", err)
-	}
 
-	return nil
+	// subscribing to the streamer's channel for the available trading pairs
+	subscribed := make(chan error, 1)
+	go func() {
+		subscribed <- s.streamer.Subscribe(coinbase.ChannelMatches, s.vwaps.tradingPairs()...)
+	}()
+
+	return s.handleFeeds(feeds, feedsErr, subscribed)
 }
 
-func (s *Service) handleFeeds(feeds <-chan []byte, feedsErr <-chan error) error {
+// handleFeeds handles the events of the feeds until the service is stopped, the feeds fail,
+// or the subscription fails
+func (s *Service) handleFeeds(feeds <-chan coinbase.Event, feedsErr <-chan error, subscribed <-chan error) error {
 	for {
 		select {
 		case <-s.stop:
@@ -114,100 +295,275 @@ func (s *Service) handleFeeds(feeds <-chan []byte, feedsErr <-chan error) error
 		case <-s.ctx.Done():
 			return nil
 
-		case fErr := <-feedsErr:
-			return fmt.Errorf("feed errors receiver: %w", fErr)
-
-		case msg := <-feeds:
-			exchMsg, err := s.parseFeedMsg(msg)
+		case err := <-subscribed:
 			if err != nil {
-				s.logger.Error("unsupported message for VWAP calculation", zap.NamedError("error", err), zap.String("msg", string(msg)))
-				continue
-			}
-			if exchMsg == nil {
-				continue
+				return fmt.Errorf("subscribe to matches channel: %w", err)
 			}
+			subscribed = nil
 
-			s.mu.Lock()
-			tpvwap, ok := s.vwaps[exchMsg.ProductID]
+		case fErr := <-feedsErr:
+			return fmt.Errorf("handle feeds: feed errors receiver: %w", fErr)
+
+		case event := <-feeds:
+			s.handleMatches(s.readEvents(event, feeds))
+		}
+	}
+}
+
+// readEvents returns the given event, and the events already waiting in the feeds, so that
+// matches arriving together are handled together, up to _maxBatchSize events
+func (s *Service) readEvents(event coinbase.Event, feeds <-chan coinbase.Event) []coinbase.Event {
+	var events []coinbase.Event
+	for n := 1; ; n++ {
+		if event = s.readEvent(event); event != nil {
+			events = append(events, event)
+		}
+		if n == _maxBatchSize {
+			return events
+		}
+
+		var ok bool
+		select {
+		case event, ok = <-feeds:
 			if !ok {
-				s.logger.Sugar().Errorf("service run: check trading pair: %s is out of scope", exchMsg.ProductID)
-				continue
+				return events
 			}
+		default:
+			return events
+		}
+	}
+}
+
+// readEvent returns a feed event when it is a match or a gap event, and logs the errors sent by the exchange
+func (s *Service) readEvent(event coinbase.Event) coinbase.Event {
+	switch e := event.(type) {
+	case coinbase.Match:
+		// the last match sent on every subscription is only used by the exchange client to detect gaps
+		if e.Type != coinbase.TypeMatch {
+			return nil
+		}
 
-			if err := tpvwap.updateVWAP(exchMsg.Price, exchMsg.Size); err != nil {
-				s.logger.Error("failed to calculate VWAP from feed message", zap.NamedError("error", err), zap.String("msg", string(msg)))
+		// fall back to the reception time when the exchange does not provide the trade time
+		if e.Time.IsZero() {
+			e.Time = time.Now()
+		}
+		return e
+	case coinbase.Gap:
+		return e
+	case coinbase.Error:
+		s.logger.Error("received an error message from the server", zap.String("message", e.Message),
+			zap.String("reason", e.Reason))
+		return nil
+	default:
+		return nil
+	}
+}
+
+// handleMatches updates the VWAP of every trading pair with its matches in a single batch,
+// and writes the updated VWAPs once per trading pair. Outliers are filtered out of a batch
+// against the VWAP computed before it
+func (s *Service) handleMatches(events []coinbase.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// matches are grouped by trading pair, in the order they were received
+	var tradingPairs []string
+	byPair := make(map[string][]coinbase.Match)
+	for _, event := range events {
+		switch e := event.(type) {
+		case coinbase.Gap:
+			s.handleGap(e)
+		case coinbase.Match:
+			if _, ok := byPair[e.ProductID]; !ok {
+				tradingPairs = append(tradingPairs, e.ProductID)
+			}
+			byPair[e.ProductID] = append(byPair[e.ProductID], e)
+		}
+	}
+
+	for _, tp := range tradingPairs {
+		tpvwap, ok := s.vwaps[tp]
+		if !ok {
+			s.logger.Sugar().Errorf("service run: check trading pair: %s is out of scope", tp)
+			continue
+		}
+
+		tpMatches := s.filterOutliers(tpvwap, byPair[tp])
+
+		updated := false
+		var newest time.Time
+		for i, err := range tpvwap.updateVWAPBatch(tpMatches) {
+			if err == nil {
+				updated = true
+				if tpMatches[i].Time.After(newest) {
+					newest = tpMatches[i].Time
+				}
 				continue
 			}
+			s.handleUpdateErr(err, tpMatches[i])
+		}
+		if !updated {
+			continue
+		}
+		tpvwap.rollOver()
+
+		if _, err := io.WriteString(s.output, tpvwap.string()+"\n"); err != nil {
+			s.logger.Error("failed to write VWAP to output target", zap.NamedError("error", err))
+		}
+		if tpvwap.History != nil {
+			tpvwap.History.push(HistoryPoint{Time: newest, Value: tpvwap.Value()})
+		}
 
-			if _, err := io.WriteString(s.output, tpvwap.string()+"\n"); err != nil {
-				s.logger.Error("failed to write VWAP to output target", zap.NamedError("error", err))
+		for _, b := range tpvwap.popBars() {
+			if _, err := io.WriteString(s.barOutput, formatBar(tp, b)+"\n"); err != nil {
+				s.logger.Error("failed to write bar to output target", zap.NamedError("error", err))
 			}
-			s.mu.Unlock()
 		}
 	}
 }
 
-func (s *Service) parseFeedMsg(msg []byte) (*ExchangeMsg, error) {
-	exchMsg := &ExchangeMsg{}
-	if err := json.Unmarshal(msg, &exchMsg); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+// handleGap marks the VWAP of a trading pair as degraded when trades were missed, until they are all
+// received late or no longer fall within the VWAP
+func (s *Service) handleGap(gap coinbase.Gap) {
+	tpvwap, ok := s.vwaps[gap.ProductID]
+	if !ok {
+		return
 	}
 
-	if exchMsg.Type == coinbase.TypeError {
-		return nil, fmt.Errorf("received an error message from the server: %s", exchMsg.Message)
+	fields := []zap.Field{
+		zap.String("trading_pair", gap.ProductID),
+		zap.Int("first_trade_id", gap.FirstTradeID),
+		zap.Int("last_trade_id", gap.LastTradeID),
 	}
-
-	// we only accept messages of 'match' type
-	if exchMsg.Type != coinbase.TypeMatch {
-		return nil, nil
+	switch gap.Type {
+	case coinbase.TypeGapRecovered:
+		tpvwap.recoverGap(gap.FirstTradeID, gap.LastTradeID)
+		s.logger.Info("missed trades recovered", fields...)
+		return
+	case coinbase.TypeGapExpired:
+		// the client only forgets gaps once their trades no longer fall within the VWAP
+		tpvwap.recoverGap(gap.FirstTradeID, gap.LastTradeID)
+		s.logger.Info("missed trades expired", fields...)
+		return
 	}
 
-	return exchMsg, nil
+	tpvwap.addGap(gap.FirstTradeID, gap.LastTradeID, gap.Time)
+	s.logger.Warn("VWAP degraded by missed trades", fields...)
 }
 
-// Stop stops the execution of the service
-func (s *Service) Stop() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// filterOutliers returns the matches of a trading pair which pass the outlier filter of the service,
+// and counts, logs and writes the others
+func (s *Service) filterOutliers(tpvwap *vwapRecord, matches []coinbase.Match) []coinbase.Match {
+	if !s.outliers.enabled() {
+		return matches
+	}
 
-	if s.running.Load() == true {
-		s.stop <- true
+	kept := matches[:0]
+	for _, m := range matches {
+		err := tpvwap.checkOutlier(s.outliers, m)
+		if err == nil {
+			kept = append(kept, m)
+			continue
+		}
+
+		s.handleUpdateErr(err, m)
+		if s.outliers.Output == nil {
+			continue
+		}
+		if _, err := io.WriteString(s.outliers.Output, formatOutlier(m, err)+"\n"); err != nil {
+			s.logger.Error("failed to write outlier to output target", zap.NamedError("error", err))
+		}
 	}
+
+	return kept
 }
 
-type vwapRecord struct {
-	VWaper
-	Name string
+// handleUpdateErr counts and logs a rejected trade, or logs the failure to update a VWAP
+func (s *Service) handleUpdateErr(err error, m coinbase.Match) {
+	if rejection := s.rejections.count(err); rejection != "" {
+		s.logger.Warn("rejected trade for VWAP calculation", zap.String("rejection", rejection),
+			zap.NamedError("error", err), zap.Any("match", m))
+		return
+	}
+
+	s.logger.Error("failed to calculate VWAP from feed message", zap.NamedError("error", err), zap.Any("match", m))
 }
 
-func (v *vwapRecord) updateVWAP(price string, volume string) error {
-	fprice, err := strconv.ParseFloat(price, 64)
+// ValueAt returns the last VWAP written for the given trading pair at or before the given instant,
+// as recorded in its history (see WithHistory)
+func (s *Service) ValueAt(tradingPair string, at time.Time) (HistoryPoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, err := s.history(tradingPair)
 	if err != nil {
-		return fmt.Errorf("parse price '%s': %w", price, err)
+		return HistoryPoint{}, err
 	}
 
-	fvolume, err := strconv.ParseFloat(volume, 64)
-	if err != nil {
-		return fmt.Errorf("parse size '%s': %w", volume, err)
+	pt, ok := h.valueAt(at)
+	if !ok {
+		return HistoryPoint{}, fmt.Errorf("history: no VWAP of %s at %s", tradingPair, at.Format(time.RFC3339Nano))
 	}
+	return pt, nil
+}
 
-	if err := v.Push(fprice, fvolume); err != nil {
-		return fmt.Errorf("push trading-pair to VWAP: %w", err)
+// History returns the VWAPs written for the given trading pair from from to to, both included,
+// from the oldest to the newest, as recorded in its history (see WithHistory)
+func (s *Service) History(tradingPair string, from time.Time, to time.Time) ([]HistoryPoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, err := s.history(tradingPair)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return h.between(from, to), nil
 }
 
-func (v vwapRecord) string() string {
-	return v.Name + ": " + strconv.FormatFloat(v.Value(), 'f', 6, 64)
+// history returns the history of the given trading pair
+func (s *Service) history(tradingPair string) (*history, error) {
+	tpvwap, ok := s.vwaps[strings.ToUpper(tradingPair)]
+	if !ok {
+		return nil, fmt.Errorf("history: unknown trading pair %s", tradingPair)
+	}
+	if tpvwap.History == nil {
+		return nil, errors.New("history: VWAP history is not recorded")
+	}
+
+	return tpvwap.History, nil
 }
 
-type vwapRecords map[string]*vwapRecord
+// Degraded returns the trading pairs whose VWAP may be missing trades, in alphabetical order
+func (s *Service) Degraded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-func (v vwapRecords) tradingPairs() []string {
 	var pairs []string
-	for tp := range v {
-		pairs = append(pairs, tp)
+	for tp, tpvwap := range s.vwaps {
+		if tpvwap.degraded() {
+			pairs = append(pairs, tp)
+		}
 	}
+	sort.Strings(pairs)
+
 	return pairs
 }
+
+// Rejections returns the number of trades rejected by the VWAPs of the service, by category
+func (s *Service) Rejections() Rejections {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rejections
+}
+
+// Stop stops the execution of the service
+func (s *Service) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running.Load() == true {
+		s.stop <- true
+	}
+}
@@ -1,16 +1,22 @@
 package service
 
 import (
+	"bytes"
 	"context"
 	"errors"
-	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
 	"io"
+	"math"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+	"vwap-service/internal/crypto-streamer/coinbase"
+	"vwap-service/internal/indicator"
 	"vwap-service/internal/vwap"
 )
 
@@ -47,6 +53,8 @@ func TestService_AddTradingPairs(t *testing.T) {
 	type fields struct {
 		vwaps      vwapRecords
 		maxDataPts int
+		window     time.Duration
+		decimal    bool
 	}
 	type args struct {
 		tradingPairs []string
@@ -94,11 +102,44 @@ func TestService_AddTradingPairs(t *testing.T) {
 				},
 			},
 		},
+		"it should add a decimal VWAP when decimal is set": {
+			args: args{
+				tradingPairs: []string{"ETH-BTC"},
+			},
+			fields: fields{
+				vwaps:   make(vwapRecords),
+				decimal: true,
+			},
+			want: vwapRecords{
+				"ETH-BTC": &vwapRecord{
+					VWaper: vwap.NewDecimal(200),
+					Name:   "ETH-BTC",
+				},
+			},
+		},
+		"it should add a time window VWAP when a window is set": {
+			args: args{
+				tradingPairs: []string{"btc-usd"},
+			},
+			fields: fields{
+				vwaps:  make(vwapRecords),
+				window: 5 * time.Minute,
+			},
+			want: vwapRecords{
+				"BTC-USD": &vwapRecord{
+					VWaper: vwap.NewTimeWindow(5 * time.Minute),
+					Name:   "BTC-USD",
+				},
+			},
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			s := &Service{
-				vwaps: tt.fields.vwaps,
+				vwaps:      tt.fields.vwaps,
+				maxDataPts: tt.fields.maxDataPts,
+				window:     tt.fields.window,
+				decimal:    tt.fields.decimal,
 			}
 			s.AddTradingPairs(tt.args.tradingPairs...)
 
@@ -107,6 +148,219 @@ func TestService_AddTradingPairs(t *testing.T) {
 	}
 }
 
+func TestService_AddTradingPair(t *testing.T) {
+	windows := []vwap.Window{
+		{Name: "50", MaxPts: 50},
+		{Name: "1m", Duration: time.Minute},
+	}
+
+	tests := map[string]struct {
+		serviceWindows []vwap.Window
+		serviceOpts    []Option
+		percentiles    []float64
+		tradingPair    string
+		opts           []PairOption
+		want           vwapRecords
+	}{
+		"it should add a trading pair with the service's options": {
+			tradingPair: "eth-btc",
+			want: vwapRecords{
+				"ETH-BTC": &vwapRecord{
+					VWaper: vwap.New(200),
+					Name:   "ETH-BTC",
+				},
+			},
+		},
+		"it should add a trading pair with its volume instead of the service's windows": {
+			serviceWindows: windows,
+			tradingPair:    "BTC-USD",
+			opts:           []PairOption{WithPairVolume(100)},
+			want: vwapRecords{
+				"BTC-USD": &vwapRecord{
+					VWaper: vwap.NewVolumeWindow(100),
+					Name:   "BTC-USD",
+				},
+			},
+		},
+		"it should add a trading pair with its volume instead of the service's anchor": {
+			serviceOpts: []Option{WithAnchor(vwap.Manual())},
+			tradingPair: "BTC-USD",
+			opts:        []PairOption{WithPairVolume(100)},
+			want: vwapRecords{
+				"BTC-USD": &vwapRecord{
+					VWaper: vwap.NewVolumeWindow(100),
+					Name:   "BTC-USD",
+				},
+			},
+		},
+		"it should add a trading pair with its windows instead of the service's half-life": {
+			serviceOpts: []Option{WithHalfLife(time.Minute)},
+			tradingPair: "BTC-USD",
+			opts:        []PairOption{WithPairWindows(windows...)},
+			want: vwapRecords{
+				"BTC-USD": &vwapRecord{
+					VWaper: vwap.NewWindows(windows),
+					Name:   "BTC-USD",
+				},
+			},
+		},
+		"it should add a trading pair with the service's windows": {
+			serviceWindows: windows,
+			tradingPair:    "ETH-BTC",
+			want: vwapRecords{
+				"ETH-BTC": &vwapRecord{
+					VWaper: vwap.NewWindows(windows),
+					Name:   "ETH-BTC",
+				},
+			},
+		},
+		"it should add a trading pair with an anchored VWAP": {
+			serviceWindows: windows,
+			tradingPair:    "BTC-USD",
+			opts:           []PairOption{WithPairAnchor(vwap.Daily(0))},
+			want: vwapRecords{
+				"BTC-USD": &vwapRecord{
+					VWaper: vwap.NewAnchored(vwap.Daily(0)),
+					Name:   "BTC-USD",
+				},
+			},
+		},
+		"it should add a trading pair with a decayed VWAP": {
+			serviceWindows: windows,
+			tradingPair:    "BTC-USD",
+			opts:           []PairOption{WithPairHalfLife(time.Minute)},
+			want: vwapRecords{
+				"BTC-USD": &vwapRecord{
+					VWaper: vwap.NewDecayed(time.Minute),
+					Name:   "BTC-USD",
+				},
+			},
+		},
+		"it should add a trading pair with its own windows": {
+			serviceWindows: windows,
+			tradingPair:    "BTC-USD",
+			opts:           []PairOption{WithPairWindows(vwap.Window{Name: "1h", Duration: time.Hour})},
+			want: vwapRecords{
+				"BTC-USD": &vwapRecord{
+					VWaper: vwap.NewWindows([]vwap.Window{{Name: "1h", Duration: time.Hour}}),
+					Name:   "BTC-USD",
+				},
+			},
+		},
+		"it should add a trading pair with a volume window": {
+			tradingPair: "BTC-USD",
+			opts:        []PairOption{WithPairVolume(100)},
+			want: vwapRecords{
+				"BTC-USD": &vwapRecord{
+					VWaper: vwap.NewVolumeWindow(100),
+					Name:   "BTC-USD",
+				},
+			},
+		},
+		"it should add a trading pair computing the service's percentiles": {
+			percentiles: []float64{5, 95},
+			tradingPair: "BTC-USD",
+			opts:        []PairOption{WithPairVolume(100)},
+			want: vwapRecords{
+				"BTC-USD": &vwapRecord{
+					VWaper: vwap.NewVolumeWindow(100, vwap.WithPercentiles(5, 95)),
+					Name:   "BTC-USD",
+				},
+			},
+		},
+		"it should add a trading pair with its indicators and skip unknown ones": {
+			tradingPair: "BTC-USD",
+			opts:        []PairOption{WithPairIndicators("twap", "unknown", "high")},
+			want: vwapRecords{
+				"BTC-USD": &vwapRecord{
+					VWaper: vwap.New(200),
+					Name:   "BTC-USD",
+					Indicators: []namedIndicator{
+						{Indicator: indicator.NewTWAP(indicator.Window{MaxPts: 200}), Name: "twap"},
+						{Indicator: indicator.NewHigh(indicator.Window{MaxPts: 200}), Name: "high"},
+					},
+				},
+			},
+		},
+		"it should compute the indicators over the window of the trading pair": {
+			tradingPair: "BTC-USD",
+			opts: []PairOption{WithPairWindows(vwap.Window{Name: "1h", Duration: time.Hour}),
+				WithPairIndicators("twap")},
+			want: vwapRecords{
+				"BTC-USD": &vwapRecord{
+					VWaper: vwap.NewWindows([]vwap.Window{{Name: "1h", Duration: time.Hour}}),
+					Name:   "BTC-USD",
+					Indicators: []namedIndicator{
+						{Indicator: indicator.NewTWAP(indicator.Window{Duration: time.Hour}), Name: "twap"},
+					},
+				},
+			},
+		},
+		"it should skip the indicators of a volume window": {
+			tradingPair: "BTC-USD",
+			opts:        []PairOption{WithPairVolume(100), WithPairIndicators("twap")},
+			want: vwapRecords{
+				"BTC-USD": &vwapRecord{
+					VWaper: vwap.NewVolumeWindow(100),
+					Name:   "BTC-USD",
+				},
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			opts := append([]Option{WithWindows(tt.serviceWindows...)}, tt.serviceOpts...)
+			if tt.percentiles != nil {
+				opts = append(opts, WithPercentiles(tt.percentiles...))
+			}
+			s := NewService(context.Background(), new(StreamerMock), opts...)
+			s.AddTradingPair(tt.tradingPair, tt.opts...)
+
+			assert.Equal(t, tt.want, s.vwaps)
+		})
+	}
+}
+
+func TestService_Reanchor(t *testing.T) {
+	at := time.Date(2022, 1, 2, 13, 30, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		tradingPair string
+		wantErr     assert.ErrorAssertionFunc
+	}{
+		"it should reanchor an anchored VWAP": {
+			tradingPair: "btc-usd",
+			wantErr:     assert.NoError,
+		},
+		"it should error when the trading pair is unknown": {
+			tradingPair: "ETH-USD",
+			wantErr: func(t assert.TestingT, err error, i ...interface{}) bool {
+				return assert.EqualError(t, err, "reanchor: unknown trading pair ETH-USD")
+			},
+		},
+		"it should error when the VWAP is not anchored": {
+			tradingPair: "ETH-BTC",
+			wantErr: func(t assert.TestingT, err error, i ...interface{}) bool {
+				return assert.EqualError(t, err, "reanchor: trading pair ETH-BTC does not compute an anchored VWAP")
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := NewService(context.Background(), new(StreamerMock))
+			s.AddTradingPair("BTC-USD", WithPairAnchor(vwap.Manual()))
+			s.AddTradingPairs("ETH-BTC")
+
+			tt.wantErr(t, s.Reanchor(tt.tradingPair, at))
+		})
+	}
+
+	s := NewService(context.Background(), new(StreamerMock), WithAnchor(vwap.Manual()))
+	s.AddTradingPairs("BTC-USD")
+	assert.NoError(t, s.Reanchor("BTC-USD", at))
+	assert.Equal(t, at, s.vwaps["BTC-USD"].VWaper.(*vwap.Anchored).Anchor())
+}
+
 func TestService_Run_assert_start_and_status(t *testing.T) {
 	type fields struct {
 		ctx        context.Context
@@ -169,11 +423,12 @@ func TestService_Run_assert_start_and_status(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			for _, record := range tt.fields.vwaps {
 				vwapMock := new(VWAPMock)
-				vwapMock.On("Push", mock.Anything, mock.Anything).Return(nil)
+				vwapMock.On("Push", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 				record.VWaper = vwapMock
 			}
 
 			streamerMock := new(StreamerMock)
+			streamerMock.On("Feeds").Return(make(chan coinbase.Event), make(chan error))
 			streamerMock.On("Subscribe", mock.Anything, mock.Anything).Return(tt.streamerOutputs.subscribe)
 
 			s := &Service{
@@ -189,6 +444,425 @@ func TestService_Run_assert_start_and_status(t *testing.T) {
 	}
 }
 
+func TestService_Run_reads_feeds_while_subscribing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	feeds := make(chan coinbase.Event)
+	streamerMock := new(StreamerMock)
+	streamerMock.On("Feeds").Return(feeds, make(chan error))
+	streamerMock.On("Subscribe", coinbase.ChannelMatches, []string{"BTC-USD"}).Return(nil).Run(func(mock.Arguments) {
+		// the exchange sends matches before acknowledging the subscription
+		select {
+		case feeds <- newMatch("BTC-USD", "100", "1", "", time.Time{}):
+		case <-time.After(time.Second):
+			t.Error("feeds were not read while subscribing")
+		}
+		cancel()
+	})
+
+	output := &bytes.Buffer{}
+	s := NewService(ctx, streamerMock, WithOutput(output))
+	s.AddTradingPairs("BTC-USD")
+
+	assert.NoError(t, s.Run())
+	assert.Equal(t, 1, strings.Count(output.String(), "\n"))
+}
+
+func TestService_handleMatches_rejections(t *testing.T) {
+	tests := map[string]struct {
+		batch bool
+	}{
+		"it should count rejections of matches handled one by one": {batch: false},
+		"it should count rejections of matches handled in a batch": {batch: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			s := NewService(context.Background(), new(StreamerMock), WithOutput(output))
+			s.AddTradingPairs("BTC-USD")
+
+			matches := []coinbase.Event{
+				newMatch("BTC-USD", "-1", "1", "", time.Time{}),
+				newMatch("BTC-USD", "NaN", "1", "", time.Time{}),
+				newMatch("BTC-USD", "1", "not-a-number", "", time.Time{}),
+				newMatch("BTC-USD", "1", "0", "", time.Time{}),
+				newMatch("BTC-USD", "2", "1", "", time.Time{}),
+			}
+
+			if tt.batch {
+				s.handleMatches(matches)
+			} else {
+				for _, m := range matches {
+					s.handleMatches([]coinbase.Event{m})
+				}
+			}
+
+			assert.Equal(t, Rejections{InvalidPrice: 2, InvalidVolume: 1, ZeroVolume: 1}, s.Rejections())
+			assert.Equal(t, 1, strings.Count(output.String(), "\n"))
+		})
+	}
+}
+
+func TestService_handleMatches_outliers(t *testing.T) {
+	output := &bytes.Buffer{}
+	outliers := &bytes.Buffer{}
+	s := NewService(context.Background(), new(StreamerMock), WithOutput(output),
+		WithOutlierFilter(OutlierFilter{MaxPercent: 10, Output: outliers}))
+	s.AddTradingPairs("BTC-USD")
+
+	t0 := time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC)
+	for _, m := range []coinbase.Match{
+		newMatch("BTC-USD", "100", "1", "", t0),
+		newMatch("BTC-USD", "1000", "1", "", t0),
+		newMatch("BTC-USD", "105", "1", "", t0),
+		newMatch("BTC-USD", "1", "1", "", t0),
+	} {
+		s.handleMatches([]coinbase.Event{m})
+	}
+
+	assert.Equal(t, Rejections{Outlier: 2}, s.Rejections())
+	assert.Equal(t, 102.5, s.vwaps["BTC-USD"].Value())
+	assert.Equal(t, 2, strings.Count(output.String(), "\n"))
+	assert.Equal(t, "BTC-USD 2022-01-02T15:04:05Z price: 1000 size: 1 error: outlier trade: price 1000 deviates 900.00% from 100.000000\n"+
+		"BTC-USD 2022-01-02T15:04:05Z price: 1 size: 1 error: outlier trade: price 1 deviates 99.02% from 102.500000\n", outliers.String())
+}
+
+func TestService_handleMatches_priceShift(t *testing.T) {
+	tests := map[string]struct {
+		filter         OutlierFilter
+		wantRejections Rejections
+		wantValue      float64
+	}{
+		"it should follow a lasting price shift after consecutive outliers": {
+			filter:         OutlierFilter{MaxPercent: 5, MaxRejections: 3},
+			wantRejections: Rejections{Outlier: 3},
+			wantValue:      (100 + 7*110) / 8.0,
+		},
+		"it should follow a lasting price shift of the last price": {
+			filter:         OutlierFilter{Reference: ReferenceLast, MaxPercent: 5, MaxRejections: 3},
+			wantRejections: Rejections{Outlier: 3},
+			wantValue:      (100 + 7*110) / 8.0,
+		},
+		"it should never follow a price shift with negative rejections": {
+			filter:         OutlierFilter{MaxPercent: 5, MaxRejections: -1},
+			wantRejections: Rejections{Outlier: 10},
+			wantValue:      100,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := NewService(context.Background(), new(StreamerMock), WithOutput(&bytes.Buffer{}),
+				WithOutlierFilter(tt.filter))
+			s.AddTradingPairs("BTC-USD")
+
+			t0 := time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC)
+			s.handleMatches([]coinbase.Event{newMatch("BTC-USD", "100", "1", "", t0)})
+			for i := 0; i < 10; i++ {
+				s.handleMatches([]coinbase.Event{newMatch("BTC-USD", "110", "1", "", t0)})
+			}
+
+			assert.Equal(t, tt.wantRejections, s.Rejections())
+			assert.InDelta(t, tt.wantValue, s.vwaps["BTC-USD"].Value(), 1e-9)
+			assert.False(t, s.vwaps["BTC-USD"].shifted)
+		})
+	}
+}
+
+func Test_vwapRecord_checkOutlier(t *testing.T) {
+	tests := map[string]struct {
+		filter  OutlierFilter
+		prices  []string
+		price   string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		"it should not filter without a reference price": {
+			filter:  OutlierFilter{MaxPercent: 1},
+			price:   "1000",
+			wantErr: assert.NoError,
+		},
+		"it should accept a price within the percentage of the VWAP": {
+			filter:  OutlierFilter{MaxPercent: 10},
+			prices:  []string{"100", "110"},
+			price:   "115",
+			wantErr: assert.NoError,
+		},
+		"it should reject a price beyond the percentage of the VWAP": {
+			filter:  OutlierFilter{MaxPercent: 10},
+			prices:  []string{"100", "110"},
+			price:   "116",
+			wantErr: assertErrorIs(ErrOutlier),
+		},
+		"it should reject a price beyond the percentage of the last price": {
+			filter:  OutlierFilter{Reference: ReferenceLast, MaxPercent: 10},
+			prices:  []string{"100", "110"},
+			price:   "98",
+			wantErr: assertErrorIs(ErrOutlier),
+		},
+		"it should reject a price beyond k standard deviations": {
+			filter:  OutlierFilter{MaxStdDevs: 3},
+			prices:  []string{"100", "102"},
+			price:   "104.5",
+			wantErr: assertErrorIs(ErrOutlier),
+		},
+		"it should accept a price within k standard deviations": {
+			filter:  OutlierFilter{MaxStdDevs: 3},
+			prices:  []string{"100", "102"},
+			price:   "103.5",
+			wantErr: assert.NoError,
+		},
+		"it should leave invalid prices to the VWAP": {
+			filter:  OutlierFilter{MaxPercent: 10},
+			prices:  []string{"100"},
+			price:   "wrong",
+			wantErr: assert.NoError,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			v := &vwapRecord{VWaper: vwap.New(200), Name: "BTC-USD"}
+			for _, price := range tt.prices {
+				m := newMatch("BTC-USD", price, "1", "", time.Now())
+				assert.NoError(t, v.checkOutlier(tt.filter, m))
+				assert.NoError(t, v.updateVWAP(m))
+			}
+
+			tt.wantErr(t, v.checkOutlier(tt.filter, newMatch("BTC-USD", tt.price, "1", "", time.Now())))
+		})
+	}
+}
+
+func assertErrorIs(target error) assert.ErrorAssertionFunc {
+	return func(t assert.TestingT, err error, msgAndArgs ...interface{}) bool {
+		return assert.ErrorIs(t, err, target, msgAndArgs...)
+	}
+}
+
+func TestService_handleMatches_gaps(t *testing.T) {
+	t0 := time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC)
+	match := func(price string, t time.Time) coinbase.Event {
+		return newMatch("BTC-USD", price, "1", "", t)
+	}
+	gap := func(typ string, t time.Time) coinbase.Event {
+		return coinbase.Gap{Type: typ, ProductID: "BTC-USD", FirstTradeID: 11, LastTradeID: 12, Time: t}
+	}
+
+	t.Run("it should degrade the VWAP until the window rolls over", func(t *testing.T) {
+		output := &bytes.Buffer{}
+		s := NewService(context.Background(), new(StreamerMock), WithOutput(output), WithMaxDataPts(2))
+		s.AddTradingPairs("BTC-USD", "ETH-USD")
+
+		s.handleMatches([]coinbase.Event{match("1", t0), gap(coinbase.TypeGap, t0.Add(time.Second)), match("2", t0.Add(time.Second))})
+		assert.Equal(t, []string{"BTC-USD"}, s.Degraded())
+		assert.True(t, strings.HasSuffix(output.String(), " degraded: true\n"))
+
+		// the window still holds the trade revealing the gap
+		s.handleMatches([]coinbase.Event{match("3", t0.Add(2*time.Second))})
+		assert.Equal(t, []string{"BTC-USD"}, s.Degraded())
+
+		s.handleMatches([]coinbase.Event{match("4", t0.Add(3*time.Second))})
+		assert.Empty(t, s.Degraded())
+		assert.False(t, strings.HasSuffix(output.String(), " degraded: true\n"))
+	})
+
+	t.Run("it should degrade the VWAP until every window rolls over", func(t *testing.T) {
+		s := NewService(context.Background(), new(StreamerMock), WithOutput(io.Discard),
+			WithWindows(vwap.Window{MaxPts: 2}, vwap.Window{Name: "1h", Duration: time.Hour}))
+		s.AddTradingPairs("BTC-USD")
+
+		s.handleMatches([]coinbase.Event{match("1", t0), gap(coinbase.TypeGap, t0.Add(time.Second)), match("2", t0.Add(time.Second))})
+		for i := 2; i < 5; i++ {
+			s.handleMatches([]coinbase.Event{match("3", t0.Add(time.Duration(i)*time.Second))})
+		}
+
+		// the 2 points window rolled over, but the 1h window still holds the trades around the gap
+		assert.Equal(t, []string{"BTC-USD"}, s.Degraded())
+
+		s.handleMatches([]coinbase.Event{match("4", t0.Add(2*time.Hour))})
+		assert.Empty(t, s.Degraded())
+	})
+
+	t.Run("it should degrade a decimal VWAP until its window rolls over", func(t *testing.T) {
+		s := NewService(context.Background(), new(StreamerMock), WithOutput(io.Discard), WithDecimal(true), WithMaxDataPts(2))
+		s.AddTradingPairs("BTC-USD")
+
+		s.handleMatches([]coinbase.Event{match("1", t0), gap(coinbase.TypeGap, t0.Add(time.Second)), match("2", t0.Add(time.Second))})
+		s.handleMatches([]coinbase.Event{match("3", t0.Add(2*time.Second))})
+		assert.Equal(t, []string{"BTC-USD"}, s.Degraded())
+
+		s.handleMatches([]coinbase.Event{match("4", t0.Add(3*time.Second))})
+		assert.Empty(t, s.Degraded())
+	})
+
+	t.Run("it should degrade the VWAP until the gap is recovered", func(t *testing.T) {
+		s := NewService(context.Background(), new(StreamerMock), WithOutput(io.Discard))
+		s.AddTradingPairs("BTC-USD")
+
+		s.handleMatches([]coinbase.Event{match("1", t0), gap(coinbase.TypeGap, t0.Add(time.Second))})
+		assert.Equal(t, []string{"BTC-USD"}, s.Degraded())
+
+		s.handleMatches([]coinbase.Event{gap(coinbase.TypeGapRecovered, t0.Add(time.Second))})
+		assert.Empty(t, s.Degraded())
+	})
+
+	t.Run("it should degrade a decayed VWAP until the gap expires", func(t *testing.T) {
+		s := NewService(context.Background(), new(StreamerMock), WithOutput(io.Discard), WithHalfLife(time.Minute))
+		s.AddTradingPairs("BTC-USD")
+
+		s.handleMatches([]coinbase.Event{match("1", t0), gap(coinbase.TypeGap, t0.Add(time.Second))})
+		s.handleMatches([]coinbase.Event{match("2", t0.Add(2*time.Hour))})
+		assert.Equal(t, []string{"BTC-USD"}, s.Degraded())
+
+		s.handleMatches([]coinbase.Event{gap(coinbase.TypeGapExpired, t0.Add(25*time.Hour))})
+		assert.Empty(t, s.Degraded())
+	})
+}
+
+func Test_history(t *testing.T) {
+	t0 := time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC)
+	h := newHistory(3)
+	for i, value := range []float64{1, 2, 3, 4} {
+		h.push(HistoryPoint{Time: t0.Add(time.Duration(i) * time.Second), Value: value})
+	}
+	// an older point is recorded at the time of the newest one
+	h.push(HistoryPoint{Time: t0, Value: 5})
+
+	want := []HistoryPoint{
+		{Time: t0.Add(2 * time.Second), Value: 3},
+		{Time: t0.Add(3 * time.Second), Value: 4},
+		{Time: t0.Add(3 * time.Second), Value: 5},
+	}
+	assert.Equal(t, want, h.between(t0, t0.Add(time.Hour)))
+	assert.Equal(t, want[:1], h.between(t0.Add(2*time.Second), t0.Add(2*time.Second)))
+	assert.Empty(t, h.between(t0, t0.Add(time.Second)))
+
+	pt, ok := h.valueAt(t0.Add(2500 * time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, want[0], pt)
+
+	pt, ok = h.valueAt(t0.Add(time.Hour))
+	assert.True(t, ok)
+	assert.Equal(t, want[2], pt)
+
+	_, ok = h.valueAt(t0.Add(time.Second))
+	assert.False(t, ok)
+}
+
+func TestService_History(t *testing.T) {
+	t0 := time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC)
+	s := NewService(context.Background(), new(StreamerMock), WithOutput(io.Discard), WithHistory(10))
+	s.AddTradingPairs("BTC-USD")
+
+	s.handleMatches([]coinbase.Event{
+		newMatch("BTC-USD", "2", "1", "", t0),
+	})
+	s.handleMatches([]coinbase.Event{
+		newMatch("BTC-USD", "4", "1", "", t0.Add(time.Second)),
+		newMatch("BTC-USD", "6", "1", "", t0.Add(2*time.Second)),
+		newMatch("BTC-USD", "-1", "1", "", t0.Add(3*time.Second)),
+	})
+
+	// a batch is recorded once, at the time of its newest accepted match
+	got, err := s.History("btc-usd", t0, t0.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, []HistoryPoint{{Time: t0, Value: 2}, {Time: t0.Add(2 * time.Second), Value: 4}}, got)
+
+	pt, err := s.ValueAt("BTC-USD", t0.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, HistoryPoint{Time: t0, Value: 2}, pt)
+
+	_, err = s.ValueAt("BTC-USD", t0.Add(-time.Second))
+	assert.EqualError(t, err, "history: no VWAP of BTC-USD at 2022-01-02T15:04:04Z")
+
+	_, err = s.History("ETH-USD", t0, t0)
+	assert.EqualError(t, err, "history: unknown trading pair ETH-USD")
+
+	noHistory := NewService(context.Background(), new(StreamerMock))
+	noHistory.AddTradingPairs("BTC-USD")
+	_, err = noHistory.ValueAt("BTC-USD", t0)
+	assert.EqualError(t, err, "history: VWAP history is not recorded")
+}
+
+func TestService_handleMatches_batch(t *testing.T) {
+	matches := []coinbase.Event{
+		newMatch("BTC-USD", "2", "1", "sell", time.Time{}),
+		newMatch("ETH-USD", "10", "1", "", time.Time{}),
+		newMatch("BTC-USD", "3", "1", "buy", time.Time{}),
+		newMatch("UNKNOWN", "3", "1", "", time.Time{}),
+		newMatch("BTC-USD", "4", "2", "", time.Time{}),
+	}
+
+	sequential := &bytes.Buffer{}
+	s := NewService(context.Background(), new(StreamerMock), WithOutput(sequential), WithIndicators("last"))
+	s.AddTradingPairs("BTC-USD", "ETH-USD")
+	for _, m := range matches {
+		s.handleMatches([]coinbase.Event{m})
+	}
+
+	batched := &bytes.Buffer{}
+	b := NewService(context.Background(), new(StreamerMock), WithOutput(batched), WithIndicators("last"))
+	b.AddTradingPairs("BTC-USD", "ETH-USD")
+	b.handleMatches(matches)
+
+	// the batch writes the VWAP of every trading pair once, in the order the trading pairs were received,
+	// with the same values as the last VWAPs written by sequential updates
+	lines := strings.Split(strings.TrimSpace(sequential.String()), "\n")
+	assert.Equal(t, lines[len(lines)-1]+"\n"+lines[1]+"\n", batched.String())
+	assert.Contains(t, batched.String(), "BTC-USD: 3.250000")
+}
+
+func TestService_handleMatches_bars(t *testing.T) {
+	t0 := time.Date(2022, 1, 2, 15, 4, 0, 0, time.UTC)
+
+	bars := &bytes.Buffer{}
+	s := NewService(context.Background(), new(StreamerMock), WithOutput(io.Discard),
+		WithBars(bars, time.Second, time.Hour), WithDecimal(true))
+	s.AddTradingPairs("BTC-USD")
+
+	for _, m := range []coinbase.Event{
+		newMatch("BTC-USD", "2", "1", "sell", t0),
+		newMatch("BTC-USD", "-1", "1", "sell", t0),
+		newMatch("BTC-USD", "4", "3", "buy", t0.Add(500*time.Millisecond)),
+		newMatch("BTC-USD", "3", "1", "", t0.Add(2*time.Second)),
+	} {
+		s.handleMatches([]coinbase.Event{m})
+	}
+
+	// only the 1s bar is completed, and rejected trades are left out of the bars
+	assert.Equal(t, "BTC-USD[1s] 2022-01-02T15:04:00Z open: 2.000000 high: 4.000000 low: 2.000000 close: 4.000000"+
+		" volume: 4.000000 vwap: 3.500000 trades: 2 buy-volume: 1.000000 sell-volume: 3.000000\n", bars.String())
+}
+
+func Test_formatInterval(t *testing.T) {
+	tests := map[time.Duration]string{
+		time.Second:                "1s",
+		time.Minute:                "1m",
+		5 * time.Minute:            "5m",
+		time.Hour:                  "1h",
+		90 * time.Minute:           "1h30m",
+		1500 * time.Millisecond:    "1.5s",
+		time.Hour + 30*time.Second: "1h0m30s",
+	}
+	for interval, want := range tests {
+		assert.Equal(t, want, formatInterval(interval))
+	}
+}
+
+func TestService_readEvents(t *testing.T) {
+	s := NewService(context.Background(), new(StreamerMock))
+
+	feeds := make(chan coinbase.Event, 3)
+	feeds <- coinbase.Subscriptions{}
+	feeds <- newMatch("ETH-USD", "2", "1", "", time.Time{})
+
+	events := s.readEvents(newMatch("BTC-USD", "1", "1", "", time.Time{}), feeds)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "BTC-USD", events[0].(coinbase.Match).ProductID)
+	assert.Equal(t, "ETH-USD", events[1].(coinbase.Match).ProductID)
+	assert.False(t, events[1].(coinbase.Match).Time.IsZero())
+	assert.Empty(t, feeds)
+}
+
 func Test_vwapRecord_updateVWAP(t *testing.T) {
 	type args struct {
 		price  string
@@ -206,7 +880,7 @@ func Test_vwapRecord_updateVWAP(t *testing.T) {
 			},
 			wantErr: func(t assert.TestingT, err error, i ...interface{}) bool {
 				assert.Error(t, err)
-				assert.Contains(t, err.Error(), "parse price 'not-a-number'")
+				assert.Contains(t, err.Error(), "invalid price 'not-a-number'")
 				return true
 			},
 		},
@@ -217,7 +891,7 @@ func Test_vwapRecord_updateVWAP(t *testing.T) {
 			},
 			wantErr: func(t assert.TestingT, err error, i ...interface{}) bool {
 				assert.Error(t, err)
-				assert.Contains(t, err.Error(), "parse size 'not-a-number'")
+				assert.Contains(t, err.Error(), "invalid volume 'not-a-number'")
 				return true
 			},
 		},
@@ -247,19 +921,127 @@ func Test_vwapRecord_updateVWAP(t *testing.T) {
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			vwaper := new(VWAPMock)
-			vwaper.On("Push", mock.Anything, mock.Anything).Return(tt.pushReturn)
+			vwaper.On("Push", mock.Anything, mock.Anything, mock.Anything).Return(tt.pushReturn)
 
 			v := &vwapRecord{
 				VWaper: vwaper,
 				Name:   "TP",
 			}
 
-			err := v.updateVWAP(tt.args.price, tt.args.volume)
+			err := v.updateVWAP(newMatch("TP", tt.args.price, tt.args.volume, "", time.Now()))
 			tt.wantErr(t, err)
 		})
 	}
 }
 
+func Test_vwapRecord_updateVWAP_decimal(t *testing.T) {
+	v := &vwapRecord{
+		VWaper: vwap.NewDecimal(200),
+		Name:   "ETH-BTC",
+	}
+
+	if err := v.updateVWAP(newMatch("ETH-BTC", "0.07812345", "1.5", "", time.Now())); err != nil {
+		t.Fatalf("updateVWAP() unexpected error = %v", err)
+	}
+	if err := v.updateVWAP(newMatch("ETH-BTC", "0.07812346", "0.5", "", time.Now())); err != nil {
+		t.Fatalf("updateVWAP() unexpected error = %v", err)
+	}
+
+	// (0.07812345*1.5 + 0.07812346*0.5) / 2 = 0.0781234525
+	assert.Equal(t, "ETH-BTC: 0.078123", v.string())
+	assert.Equal(t, "0.0781234525", v.VWaper.(DecimalVWaper).DecimalValue(10))
+
+	err := v.updateVWAP(newMatch("ETH-BTC", "wrong", "1", "", time.Now()))
+	assert.EqualError(t, err, "push trading-pair to VWAP: invalid price 'wrong'")
+}
+
+func Test_vwapRecord_updateVWAP_indicators(t *testing.T) {
+	failing := new(VWAPMock)
+	failing.On("Push", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("some error"))
+
+	v := &vwapRecord{
+		VWaper: vwap.NewDecimal(200),
+		Name:   "ETH-BTC",
+		Indicators: []namedIndicator{
+			{Indicator: indicator.NewLast(), Name: "last"},
+			{Indicator: failing, Name: "failing"},
+		},
+	}
+
+	err := v.updateVWAP(newMatch("ETH-BTC", "0.5", "2", "", time.Now()))
+	assert.EqualError(t, err, "push trading-pair to failing indicator: some error")
+	assert.Equal(t, 0.5, v.Indicators[0].Value())
+	assert.Equal(t, "0.5", v.VWaper.(DecimalVWaper).DecimalValue(1))
+}
+
+func Test_vwapRecord_string(t *testing.T) {
+	tests := map[string]struct {
+		vwaper     VWaper
+		indicators []string
+		want       string
+	}{
+		"it should return the VWAP of the trading pair": {
+			vwaper: vwap.New(200),
+			want: "BTC-USD: 2.500000 sd: 0.500000 -2sd: 1.500000 -1sd: 2.000000 +1sd: 3.000000 +2sd: 3.500000" +
+				" buy: 2.000000 buy-volume: 1.000000 sell: 3.000000 sell-volume: 1.000000",
+		},
+		"it should return one line per window tagged with the window name": {
+			vwaper: vwap.NewWindows([]vwap.Window{
+				{Name: "1", MaxPts: 1},
+				{Name: "1m", Duration: time.Minute},
+			}),
+			want: "BTC-USD[1]: 3.000000 sd: 0.000000 -2sd: 3.000000 -1sd: 3.000000 +1sd: 3.000000 +2sd: 3.000000" +
+				" buy: 0.000000 buy-volume: 0.000000 sell: 3.000000 sell-volume: 1.000000\n" +
+				"BTC-USD[1m]: 2.500000 sd: 0.500000 -2sd: 1.500000 -1sd: 2.000000 +1sd: 3.000000 +2sd: 3.500000" +
+				" buy: 2.000000 buy-volume: 1.000000 sell: 3.000000 sell-volume: 1.000000",
+		},
+		"it should return the median and percentiles of the prices": {
+			vwaper: vwap.New(200, vwap.WithPercentiles(5, 95)),
+			want: "BTC-USD: 2.500000 sd: 0.500000 -2sd: 1.500000 -1sd: 2.000000 +1sd: 3.000000 +2sd: 3.500000" +
+				" buy: 2.000000 buy-volume: 1.000000 sell: 3.000000 sell-volume: 1.000000" +
+				" median: 2.000000 p5: 2.000000 p95: 3.000000",
+		},
+		"it should return the VWAP without bands when not available": {
+			vwaper: vwap.NewDecimal(200),
+			want:   "BTC-USD: 2.500000",
+		},
+		"it should return the indicators after the first VWAP": {
+			vwaper: vwap.NewWindows([]vwap.Window{
+				{Name: "1", MaxPts: 1},
+				{Name: "2", MaxPts: 2},
+			}),
+			indicators: []string{"twap", "mean", "last", "high", "low"},
+			want: "BTC-USD[1]: 3.000000 sd: 0.000000 -2sd: 3.000000 -1sd: 3.000000 +1sd: 3.000000 +2sd: 3.000000" +
+				" buy: 0.000000 buy-volume: 0.000000 sell: 3.000000 sell-volume: 1.000000" +
+				" twap: 2.500000 mean: 2.500000 last: 3.000000 high: 3.000000 low: 2.000000\n" +
+				"BTC-USD[2]: 2.500000 sd: 0.500000 -2sd: 1.500000 -1sd: 2.000000 +1sd: 3.000000 +2sd: 3.500000" +
+				" buy: 2.000000 buy-volume: 1.000000 sell: 3.000000 sell-volume: 1.000000",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := NewService(context.Background(), new(StreamerMock))
+			v := &vwapRecord{
+				VWaper:     tt.vwaper,
+				Name:       "BTC-USD",
+				Indicators: s.newIndicators(pairOptions{indicators: tt.indicators}),
+			}
+
+			now := time.Now()
+			assert.NoError(t, v.updateVWAP(newMatch("BTC-USD", "2", "1", "sell", now)))
+			assert.NoError(t, v.updateVWAP(newMatch("BTC-USD", "3", "1", "buy", now)))
+
+			assert.Equal(t, tt.want, v.string())
+		})
+	}
+}
+
+func Test_aggressorSide(t *testing.T) {
+	assert.Equal(t, vwap.SideBuy, aggressorSide("sell"))
+	assert.Equal(t, vwap.SideSell, aggressorSide("buy"))
+	assert.Equal(t, vwap.SideUnknown, aggressorSide(""))
+}
+
 func Test_vwapRecords_tradingPairs(t *testing.T) {
 	tests := map[string]struct {
 		v    vwapRecords
@@ -295,70 +1077,57 @@ func Test_vwapRecords_tradingPairs(t *testing.T) {
 	}
 }
 
-func TestService_parseFeedMsg(t *testing.T) {
-	type args struct {
-		msg []byte
-	}
+func TestService_readEvent(t *testing.T) {
+	t0 := time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC)
+
 	tests := map[string]struct {
-		args    args
-		want    *ExchangeMsg
-		wantErr assert.ErrorAssertionFunc
+		event coinbase.Event
+		want  coinbase.Event
 	}{
-		"it should successfully return a new ExchangeMessage": {
-			args: args{
-				msg: []byte(`{"type": "match", "product_id": "ETH-BTC", "price": "5.0", "size": "0.333", "side": "buy"}`),
-			},
-			want: &ExchangeMsg{
-				Type:      "match",
-				ProductID: "ETH-BTC",
-				Size:      "5.0",
-				Price:     "0.333",
-				Side:      "buy",
-			},
-			wantErr: func(t assert.TestingT, err error, i ...interface{}) bool {
-				return false
-			},
+		"it should return a match": {
+			event: newMatch("ETH-BTC", "5.0", "0.333", "buy", t0),
+			want:  newMatch("ETH-BTC", "5.0", "0.333", "buy", t0),
 		},
-		"it should throw an error for invalid msg": {
-			args: args{
-				msg: []byte(`wrong message`),
-			},
-			want: nil,
-			wantErr: func(t assert.TestingT, err error, i ...interface{}) bool {
-				assert.Error(t, err)
-				assert.EqualError(t, err, "failed to unmarshal message: invalid character 'w' looking for beginning of value")
-				return true
-			},
+		"it should return a gap event": {
+			event: coinbase.Gap{Type: coinbase.TypeGap, ProductID: "ETH-BTC", FirstTradeID: 11, LastTradeID: 12},
+			want:  coinbase.Gap{Type: coinbase.TypeGap, ProductID: "ETH-BTC", FirstTradeID: 11, LastTradeID: 12},
 		},
-		"it should throw an error if message type is 'error'": {
-			args: args{
-				msg: []byte(`{"type": "error", "message": "no data"}`),
-			},
-			want: nil,
-			wantErr: func(t assert.TestingT, err error, i ...interface{}) bool {
-				assert.Error(t, err)
-				assert.EqualError(t, err, "received an error message from the server: no data")
-				return true
-			},
+		"it should return nothing for the last match": {
+			event: coinbase.Match{Type: coinbase.TypeLastMatch, ProductID: "ETH-BTC", Price: 1},
 		},
-		"it should return nothing if type is not 'match'": {
-			args: args{
-				msg: []byte(`{"type": "last_match", "price": "1.0"}`),
-			},
-			want: nil,
-			wantErr: func(t assert.TestingT, err error, i ...interface{}) bool {
-				return false
-			},
+		"it should return nothing for an error": {
+			event: coinbase.Error{Message: "no data"},
+		},
+		"it should return nothing for other events": {
+			event: coinbase.Heartbeat{ProductID: "ETH-BTC"},
 		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
-			s := &Service{}
-			got, err := s.parseFeedMsg(tt.args.msg)
-			if !tt.wantErr(t, err, fmt.Sprintf("parseFeedMsg(%v)", tt.args.msg)) {
-				return
-			}
-			assert.Equalf(t, tt.want, got, "parseFeedMsg(%v)", tt.args.msg)
+			s := &Service{logger: zap.NewNop()}
+			assert.Equal(t, tt.want, s.readEvent(tt.event))
 		})
 	}
 }
+
+// newMatch returns a match of the exchange, with its price and size decoded as the exchange client does
+func newMatch(productID string, price string, size string, side string, t time.Time) coinbase.Match {
+	parse := func(s string) float64 {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return math.NaN()
+		}
+		return f
+	}
+
+	return coinbase.Match{
+		Type:         coinbase.TypeMatch,
+		ProductID:    productID,
+		Price:        parse(price),
+		Size:         parse(size),
+		DecimalPrice: price,
+		DecimalSize:  size,
+		Side:         side,
+		Time:         t,
+	}
+}
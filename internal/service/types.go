@@ -1,31 +1,115 @@
 package service
 
 import (
+	"errors"
+	"time"
 	"vwap-service/internal/crypto-streamer/coinbase"
 	"vwap-service/internal/vwap"
 )
 
-type ExchangeMsg struct {
-	Type      string `json:"type"`
-	Message   string `json:"message"`
-	ProductID string `json:"product_id"`
-	Size      string `json:"size"`
-	Price     string `json:"price"`
-	Side      string `json:"side"`
-}
-
 type VWaper interface {
 	Value() float64
 	NPoints() int
-	Push(price float64, volume float64) error
+	Push(price float64, volume float64, t time.Time) error
 }
 
 var _ VWaper = (*vwap.VWAP)(nil)
+var _ VWaper = (*vwap.Anchored)(nil)
+var _ VWaper = (*vwap.Decayed)(nil)
+
+// DecimalVWaper is a VWaper able to compute the VWAP from the exact decimal prices
+// and volumes sent by the exchange
+type DecimalVWaper interface {
+	VWaper
+	PushDecimal(price string, volume string, t time.Time) error
+	DecimalValue(prec int) string
+}
+
+var _ DecimalVWaper = (*vwap.Decimal)(nil)
+
+// Windower is implemented by VWapers computing a VWAP for several named windows
+type Windower interface {
+	Windows() []vwap.WindowValue
+}
+
+var _ Windower = (*vwap.VWAP)(nil)
+
+// Deviationer is implemented by VWapers computing the volume-weighted standard deviation of prices
+type Deviationer interface {
+	StdDev() float64
+	Bands(k float64) (lower float64, upper float64)
+}
+
+var _ Deviationer = (*vwap.VWAP)(nil)
+var _ Deviationer = (*vwap.Anchored)(nil)
+var _ Deviationer = (*vwap.Decayed)(nil)
+
+// SideSplitter is implemented by VWapers also computing the VWAP and volume of the buy
+// and sell aggressors
+type SideSplitter interface {
+	PushSide(price float64, volume float64, t time.Time, side vwap.Side) error
+	Side(side vwap.Side) vwap.SideValue
+}
+
+var _ SideSplitter = (*vwap.VWAP)(nil)
+
+// BatchPusher is implemented by VWapers able to push several trades at once
+type BatchPusher interface {
+	PushBatch(trades []vwap.Trade) error
+}
+
+var _ BatchPusher = (*vwap.VWAP)(nil)
+
+// Anchorer is implemented by VWapers computed from an anchor instant
+type Anchorer interface {
+	Anchor() time.Time
+	Reanchor(at time.Time)
+}
+
+var _ Anchorer = (*vwap.Anchored)(nil)
+
+// Oldester is implemented by VWapers computed over rolling windows, returning the trade time of the oldest
+// data point held by any of them
+type Oldester interface {
+	Oldest() time.Time
+}
+
+var _ Oldester = (*vwap.VWAP)(nil)
+var _ Oldester = (*vwap.Decimal)(nil)
+
+// Rejections is the number of trades rejected by the VWAPs of the service, by category
+type Rejections struct {
+	InvalidPrice  uint64
+	InvalidVolume uint64
+	ZeroVolume    uint64
+	Outlier       uint64
+}
+
+// count counts the trade rejected with err in its category, and returns the name of the category,
+// or an empty string when err is not a rejection
+func (r *Rejections) count(err error) string {
+	switch {
+	case errors.Is(err, vwap.ErrInvalidPrice):
+		r.InvalidPrice++
+		return "invalid_price"
+	case errors.Is(err, vwap.ErrInvalidVolume):
+		r.InvalidVolume++
+		return "invalid_volume"
+	case errors.Is(err, vwap.ErrZeroVolume):
+		r.ZeroVolume++
+		return "zero_volume"
+	case errors.Is(err, ErrOutlier):
+		r.Outlier++
+		return "outlier"
+	default:
+		return ""
+	}
+}
 
 type Streamer interface {
 	Subscribe(channel string, productIDs ...string) error
 	Unsubscribe(channel string, productID ...string) error
-	Feeds() (feeds chan []byte, feedsErr chan error)
+	Feeds() (feeds chan coinbase.Event, feedsErr chan error)
 	Close() error
 }
 
@@ -34,6 +118,11 @@ var _ Streamer = (*coinbase.WSClient)(nil)
 type Servicer interface {
 	Run() error
 	AddTradingPairs(pairs ...string)
+	Reanchor(tradingPair string, at time.Time) error
+	Rejections() Rejections
+	Degraded() []string
+	ValueAt(tradingPair string, at time.Time) (HistoryPoint, error)
+	History(tradingPair string, from time.Time, to time.Time) ([]HistoryPoint, error)
 	Stop()
 }
 
@@ -0,0 +1,175 @@
+package vwap
+
+import (
+	"sync"
+	"time"
+)
+
+// Schedule defines the sessions of an Anchored VWAP
+type Schedule interface {
+	// Session returns the start of the session the trade time t belongs to
+	Session(t time.Time) time.Time
+}
+
+// Daily returns a Schedule starting a new session every day at UTC midnight plus the given offset
+// e.g. Daily(0) starts sessions at UTC midnight, Daily(13*time.Hour + 30*time.Minute) at 13:30 UTC
+// The offset is taken modulo a day, so that Daily(-time.Hour) starts sessions at 23:00 UTC
+func Daily(offset time.Duration) Schedule {
+	offset %= 24 * time.Hour
+	if offset < 0 {
+		offset += 24 * time.Hour
+	}
+	return daily{offset: offset}
+}
+
+type daily struct {
+	offset time.Duration
+}
+
+func (d daily) Session(t time.Time) time.Time {
+	t = t.UTC()
+	session := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Add(d.offset)
+	if session.After(t) {
+		session = session.AddDate(0, 0, -1)
+	}
+	return session
+}
+
+// Manual returns a Schedule which never starts a new session, the Anchored VWAP only resets
+// when it is re-anchored
+func Manual() Schedule {
+	return manual{}
+}
+
+type manual struct{}
+
+func (m manual) Session(time.Time) time.Time {
+	return time.Time{}
+}
+
+// Anchored is used to compute the VWAP of every data point traded since an anchor, which is
+// either the start of the current session of its Schedule, or a manually chosen instant.
+// Unlike VWAP, it does not hold any data point and grows until the next reset
+type Anchored struct {
+	mux      *sync.Mutex
+	schedule Schedule
+
+	anchor time.Time
+	nPts   int
+	nQ     int
+	sumPQ  neumaier
+	sumP2Q neumaier
+	sumQ   neumaier
+	vwap   float64
+	stdDev float64
+}
+
+// NewAnchored creates a new Anchored VWAP resetting at the start of each session of the
+// given schedule. When schedule is nil, the VWAP only resets when it is re-anchored
+func NewAnchored(schedule Schedule) *Anchored {
+	if schedule == nil {
+		schedule = Manual()
+	}
+
+	return &Anchored{
+		mux:      &sync.Mutex{},
+		schedule: schedule,
+	}
+}
+
+// Value returns the value of the pre-computed VWAP
+func (a *Anchored) Value() float64 {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	return a.vwap
+}
+
+// StdDev returns the volume-weighted standard deviation of the prices pushed since the anchor
+func (a *Anchored) StdDev() float64 {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	return a.stdDev
+}
+
+// Bands returns the VWAP minus and plus k standard deviations
+func (a *Anchored) Bands(k float64) (lower float64, upper float64) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	return bands(a.vwap, a.stdDev, k)
+}
+
+// NPoints returns the number of data points pushed since the anchor
+func (a *Anchored) NPoints() int {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	return a.nPts
+}
+
+// Anchor returns the instant from which the VWAP is computed
+func (a *Anchored) Anchor() time.Time {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	return a.anchor
+}
+
+// Reanchor resets the VWAP so that it is computed from the given instant
+// Data points traded before the anchor are ignored
+func (a *Anchored) Reanchor(at time.Time) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	a.reset(at)
+}
+
+// Push uses the provided price, volume and trade time to recompute the VWAP
+// The VWAP is reset first when the trade time belongs to a new session, while data
+// points traded before the anchor are ignored
+func (a *Anchored) Push(price float64, volume float64, t time.Time) error {
+	if err := validate(price, volume); err != nil {
+		return err
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if t.Before(a.anchor) {
+		return nil
+	}
+
+	if session := a.schedule.Session(t); session.After(a.anchor) {
+		a.reset(session)
+	}
+
+	nQ := a.nQ
+	if volume != 0 {
+		nQ++
+	}
+	if nQ == 0 {
+		return ErrZeroVolume
+	}
+
+	a.nPts++
+	a.nQ = nQ
+	a.sumPQ.add(price * volume)
+	a.sumP2Q.add(price * price * volume)
+	a.sumQ.add(volume)
+	a.vwap, a.stdDev = vwapStdDev(a.sumPQ.value(), a.sumP2Q.value(), a.sumQ.value())
+
+	return nil
+}
+
+func (a *Anchored) reset(anchor time.Time) {
+	a.anchor = anchor
+	a.nPts = 0
+	a.nQ = 0
+	a.sumPQ = neumaier{}
+	a.sumP2Q = neumaier{}
+	a.sumQ = neumaier{}
+	a.vwap = 0
+	a.stdDev = 0
+}
@@ -0,0 +1,193 @@
+package vwap
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDaily_Session(t *testing.T) {
+	tests := map[string]struct {
+		offset time.Duration
+		t      time.Time
+		want   time.Time
+	}{
+		"it should start sessions at UTC midnight": {
+			offset: 0,
+			t:      time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC),
+			want:   time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		"it should return the same day session after the offset": {
+			offset: 13*time.Hour + 30*time.Minute,
+			t:      time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC),
+			want:   time.Date(2022, 1, 2, 13, 30, 0, 0, time.UTC),
+		},
+		"it should return the previous day session before the offset": {
+			offset: 13*time.Hour + 30*time.Minute,
+			t:      time.Date(2022, 1, 2, 9, 0, 0, 0, time.UTC),
+			want:   time.Date(2022, 1, 1, 13, 30, 0, 0, time.UTC),
+		},
+		"it should start sessions before UTC midnight with a negative offset": {
+			offset: -time.Hour,
+			t:      time.Date(2022, 1, 2, 23, 30, 0, 0, time.UTC),
+			want:   time.Date(2022, 1, 2, 23, 0, 0, 0, time.UTC),
+		},
+		"it should return the previous day session with a negative offset": {
+			offset: -time.Hour,
+			t:      time.Date(2022, 1, 2, 22, 0, 0, 0, time.UTC),
+			want:   time.Date(2022, 1, 1, 23, 0, 0, 0, time.UTC),
+		},
+		"it should convert trade times to UTC": {
+			offset: 0,
+			t:      time.Date(2022, 1, 2, 1, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60)),
+			want:   time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := Daily(tt.offset).Session(tt.t); !got.Equal(tt.want) {
+				t.Errorf("Session() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnchored_Push(t *testing.T) {
+	day := time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	type push struct {
+		price  float64
+		volume float64
+		time   time.Time
+	}
+	tests := map[string]struct {
+		schedule   Schedule
+		reanchor   time.Time
+		pushes     []push
+		wantErr    error
+		wantVWAP   float64
+		wantNPts   int
+		wantAnchor time.Time
+	}{
+		"it should grow during a session": {
+			schedule: Daily(0),
+			pushes: []push{
+				{5, 2, day.Add(time.Hour)},
+				{4, 5, day.Add(2 * time.Hour)},
+				{3, 1, day.Add(23 * time.Hour)},
+			},
+			wantVWAP:   33. / 8.,
+			wantNPts:   3,
+			wantAnchor: day,
+		},
+		"it should reset when a new session starts": {
+			schedule: Daily(0),
+			pushes: []push{
+				{5, 2, day.Add(time.Hour)},
+				{4, 5, day.Add(2 * time.Hour)},
+				{3, 1, day.Add(25 * time.Hour)},
+			},
+			wantVWAP:   3,
+			wantNPts:   1,
+			wantAnchor: day.AddDate(0, 0, 1),
+		},
+		"it should ignore trades older than the anchor": {
+			schedule: Manual(),
+			reanchor: day.Add(time.Hour),
+			pushes: []push{
+				{5, 2, day},
+				{4, 5, day.Add(2 * time.Hour)},
+			},
+			wantVWAP:   4,
+			wantNPts:   1,
+			wantAnchor: day.Add(time.Hour),
+		},
+		"it should never reset with a manual schedule": {
+			schedule: nil,
+			pushes: []push{
+				{5, 2, day},
+				{4, 5, day.AddDate(0, 0, 3)},
+			},
+			wantVWAP:   30. / 7.,
+			wantNPts:   2,
+			wantAnchor: time.Time{},
+		},
+		"it should return division by 0 error": {
+			schedule: Daily(0),
+			pushes: []push{
+				{5, 0, day},
+			},
+			wantErr:    ErrZeroVolume,
+			wantAnchor: day,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := NewAnchored(tt.schedule)
+			if !tt.reanchor.IsZero() {
+				a.Reanchor(tt.reanchor)
+			}
+
+			var err error
+			for _, p := range tt.pushes {
+				err = a.Push(p.price, p.volume, p.time)
+			}
+
+			if err != tt.wantErr {
+				t.Errorf("Push() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := a.Value(); got != tt.wantVWAP {
+				t.Errorf("Value() = %v, want %v", got, tt.wantVWAP)
+			}
+			if got := a.NPoints(); got != tt.wantNPts {
+				t.Errorf("NPoints() = %v, want %v", got, tt.wantNPts)
+			}
+			if got := a.Anchor(); !got.Equal(tt.wantAnchor) {
+				t.Errorf("Anchor() = %v, want %v", got, tt.wantAnchor)
+			}
+		})
+	}
+}
+
+func TestAnchored_Reanchor(t *testing.T) {
+	day := time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	a := NewAnchored(Daily(0))
+	_ = a.Push(5, 2, day.Add(time.Hour))
+	_ = a.Push(4, 5, day.Add(2*time.Hour))
+
+	a.Reanchor(day.Add(3 * time.Hour))
+	if a.NPoints() != 0 || a.Value() != 0 {
+		t.Errorf("Reanchor() should reset the VWAP, got %d points and value %v", a.NPoints(), a.Value())
+	}
+
+	_ = a.Push(3, 1, day.Add(4*time.Hour))
+	if got := a.Value(); got != 3 {
+		t.Errorf("Value() = %v, want %v", got, 3)
+	}
+
+	// the schedule keeps resetting the VWAP at the start of the next session
+	_ = a.Push(6, 1, day.Add(25*time.Hour))
+	if got := a.Anchor(); !got.Equal(day.AddDate(0, 0, 1)) {
+		t.Errorf("Anchor() = %v, want %v", got, day.AddDate(0, 0, 1))
+	}
+	if got := a.Value(); got != 6 {
+		t.Errorf("Value() = %v, want %v", got, 6)
+	}
+}
+
+func TestAnchored_StdDev(t *testing.T) {
+	a := NewAnchored(Manual())
+	_ = a.Push(1, 1, time.Time{})
+	_ = a.Push(3, 3, time.Time{})
+
+	// vwap = (1*1 + 3*3) / 4 = 2.5, variance = (1*(1-2.5)² + 3*(3-2.5)²) / 4 = 0.75
+	if got := a.StdDev(); math.Abs(got-math.Sqrt(0.75)) > 1e-12 {
+		t.Errorf("StdDev() = %v, want %v", got, math.Sqrt(0.75))
+	}
+
+	lower, upper := a.Bands(1)
+	if math.Abs(lower-(2.5-math.Sqrt(0.75))) > 1e-12 || math.Abs(upper-(2.5+math.Sqrt(0.75))) > 1e-12 {
+		t.Errorf("Bands(1) = %v, %v, want %v, %v", lower, upper, 2.5-math.Sqrt(0.75), 2.5+math.Sqrt(0.75))
+	}
+}
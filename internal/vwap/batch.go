@@ -0,0 +1,61 @@
+package vwap
+
+import (
+	"fmt"
+	"time"
+)
+
+// Trade is a single trade of a batch pushed to a VWAP (see PushBatch)
+type Trade struct {
+	Price  float64
+	Volume float64
+	Time   time.Time
+	Side   Side
+}
+
+// TradeError is the error of a trade rejected from a batch
+type TradeError struct {
+	// Index is the index of the rejected trade in the batch
+	Index int
+	Err   error
+}
+
+// BatchError reports the trades rejected from a batch, every other trade of the batch being pushed
+type BatchError struct {
+	Errs []TradeError
+}
+
+// Error returns the number of rejected trades, and the error of the first one
+func (b *BatchError) Error() string {
+	return fmt.Sprintf("%d rejected trades, trade %d: %v", len(b.Errs), b.Errs[0].Index, b.Errs[0].Err)
+}
+
+// Unwrap returns the error of the first rejected trade
+func (b *BatchError) Unwrap() error {
+	return b.Errs[0].Err
+}
+
+// PushBatch pushes the given trades in order under a single lock, with the same results as pushing
+// them one by one with PushSide. Rejected trades are skipped and reported by a *BatchError
+func (v *VWAP) PushBatch(trades []Trade) error {
+	var errs []TradeError
+
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	for i, trade := range trades {
+		err := validate(trade.Price, trade.Volume)
+		if err == nil {
+			err = v.push(trade.Price, trade.Volume, trade.Time, trade.Side)
+		}
+		if err != nil {
+			errs = append(errs, TradeError{Index: i, Err: err})
+		}
+	}
+
+	if len(errs) > 0 {
+		return &BatchError{Errs: errs}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package vwap
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVWAP_PushBatch(t *testing.T) {
+	t0 := time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	var trades []Trade
+	for i := 0; i < 500; i++ {
+		trades = append(trades, Trade{
+			Price:  100 + math.Sin(float64(i)),
+			Volume: float64(i%7) + 0.25,
+			Time:   t0.Add(time.Duration(i) * time.Second),
+			Side:   Side(i % 3),
+		})
+	}
+	// rejected trades are skipped, and do not prevent the next ones from being pushed
+	trades[10].Price = math.NaN()
+	trades[20].Volume = -1
+
+	newVWAP := func() *VWAP {
+		return NewWindows([]Window{
+			{Name: "50", MaxPts: 50},
+			{Name: "1m", Duration: time.Minute},
+		}, WithRecomputeEvery(64))
+	}
+
+	sequential := newVWAP()
+	for _, trade := range trades {
+		_ = sequential.PushSide(trade.Price, trade.Volume, trade.Time, trade.Side)
+	}
+
+	batch := newVWAP()
+	err := batch.PushBatch(trades)
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("PushBatch() error = %v, want a *BatchError", err)
+	}
+	if len(batchErr.Errs) != 2 || batchErr.Errs[0].Index != 10 || batchErr.Errs[1].Index != 20 {
+		t.Errorf("PushBatch() rejected trades = %v, want trades 10 and 20", batchErr.Errs)
+	}
+	if !errors.Is(err, ErrInvalidPrice) || !errors.Is(batchErr.Errs[1].Err, ErrInvalidVolume) {
+		t.Errorf("PushBatch() errors = %v, want invalid price and invalid volume", batchErr.Errs)
+	}
+
+	if !reflect.DeepEqual(batch.Windows(), sequential.Windows()) {
+		t.Errorf("Windows() = %v, want %v", batch.Windows(), sequential.Windows())
+	}
+	if !reflect.DeepEqual(batch.dataPts, sequential.dataPts) {
+		t.Errorf("PushBatch() data points differ from sequential pushes")
+	}
+}
+
+func TestVWAP_PushBatch_no_error(t *testing.T) {
+	v := New(10)
+	if err := v.PushBatch([]Trade{{Price: 2, Volume: 1}, {Price: 3, Volume: 1}}); err != nil {
+		t.Fatalf("PushBatch() unexpected error = %v", err)
+	}
+	if got := v.Value(); got != 2.5 {
+		t.Errorf("Value() = %v, want %v", got, 2.5)
+	}
+}
+
+func BenchmarkVWAP_PushBatch(b *testing.B) {
+	v := New(defaultMaxDataPoints)
+	t0 := time.Now()
+
+	trades := make([]Trade, 100)
+	for i := range trades {
+		trades[i] = Trade{Price: float64(i + 1), Volume: 0.5, Time: t0}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = v.PushBatch(trades)
+	}
+}
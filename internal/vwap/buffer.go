@@ -0,0 +1,66 @@
+package vwap
+
+import (
+	"time"
+)
+
+// Point is a single trade held by a Buffer
+type Point struct {
+	Price  float64
+	Volume float64
+	Time   time.Time
+}
+
+// Buffer holds the trades within a window, from the oldest to the newest, evicting them like the
+// windows of VWAP do. It lets other indicators be computed over the same trades as a VWAP
+// Windows bounded by volume are not supported, they are bounded by their MaxPts data points instead
+type Buffer struct {
+	window  *window
+	dataPts ring
+	evicted []Point
+}
+
+// NewBuffer creates a new Buffer holding the trades within the given window
+func NewBuffer(spec Window) *Buffer {
+	if spec.Duration <= 0 {
+		spec.Volume = 0
+	}
+	w := newWindow(spec)
+
+	capacity := w.spec.MaxPts
+	if capacity == 0 {
+		capacity = defaultMaxDataPoints
+	}
+
+	return &Buffer{
+		window:  w,
+		dataPts: newRing(capacity),
+	}
+}
+
+// Len returns the number of trades held by the buffer
+func (b *Buffer) Len() int {
+	return b.dataPts.len()
+}
+
+// At returns the i-th oldest trade held by the buffer
+func (b *Buffer) At(i int) Point {
+	pt := b.dataPts.at(i)
+	return Point{Price: pt.price, Volume: pt.volume, Time: pt.time}
+}
+
+// Push adds a new trade and returns the trades which fell off, from the oldest to the newest
+// The returned slice is only valid until the next push
+func (b *Buffer) Push(price float64, volume float64, t time.Time) []Point {
+	n := b.window.nEvictable(&b.dataPts, t)
+
+	b.evicted = b.evicted[:0]
+	for i := 0; i < n; i++ {
+		b.evicted = append(b.evicted, b.At(i))
+	}
+
+	b.dataPts.drop(n)
+	b.dataPts.push(newDataPoint(price, volume, t, SideUnknown))
+
+	return b.evicted
+}
@@ -0,0 +1,59 @@
+package vwap
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuffer_Push(t *testing.T) {
+	t0 := time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := map[string]struct {
+		window      Window
+		times       []time.Time
+		wantEvicted []Point
+		wantLen     int
+	}{
+		"it should evict the oldest trade beyond the max number of trades": {
+			window:      Window{MaxPts: 2},
+			times:       []time.Time{t0, t0, t0},
+			wantEvicted: []Point{{Price: 0, Volume: 1, Time: t0}},
+			wantLen:     2,
+		},
+		"it should evict the trades older than the duration": {
+			window: Window{Duration: time.Minute},
+			times:  []time.Time{t0, t0.Add(time.Second), t0.Add(2 * time.Minute)},
+			wantEvicted: []Point{
+				{Price: 0, Volume: 1, Time: t0},
+				{Price: 1, Volume: 1, Time: t0.Add(time.Second)},
+			},
+			wantLen: 1,
+		},
+		"it should fall back to the default max number of trades for volume windows": {
+			window:  Window{Volume: 1},
+			times:   []time.Time{t0, t0, t0},
+			wantLen: 3,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := NewBuffer(tt.window)
+
+			var evicted []Point
+			for i, tm := range tt.times {
+				evicted = b.Push(float64(i), 1, tm)
+			}
+
+			if !reflect.DeepEqual(evicted, tt.wantEvicted) {
+				t.Errorf("Push() = %v, want %v", evicted, tt.wantEvicted)
+			}
+			if got := b.Len(); got != tt.wantLen {
+				t.Errorf("Len() = %d, want %d", got, tt.wantLen)
+			}
+			if got := b.At(b.Len() - 1); got.Price != float64(len(tt.times)-1) {
+				t.Errorf("At() = %v, want the newest trade", got)
+			}
+		})
+	}
+}
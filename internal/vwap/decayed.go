@@ -0,0 +1,118 @@
+package vwap
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHalfLife = 5 * time.Minute
+)
+
+// Decayed is used to compute an exponentially time-decayed VWAP, where the weight of each
+// data point is its volume halved every halfLife since its trade time. Unlike VWAP, it does
+// not hold any data point, and the value does not jump when a large trade gets old
+type Decayed struct {
+	mux      *sync.Mutex
+	halfLife time.Duration
+
+	last   time.Time
+	nPts   int
+	sumPQ  float64
+	sumP2Q float64
+	sumQ   float64
+	vwap   float64
+	stdDev float64
+}
+
+// NewDecayed creates a new Decayed VWAP with the given half-life. When halfLife is less
+// than or equal to 0, it falls back to a half-life of 5 minutes
+func NewDecayed(halfLife time.Duration) *Decayed {
+	if halfLife <= 0 {
+		halfLife = defaultHalfLife
+	}
+
+	return &Decayed{
+		mux:      &sync.Mutex{},
+		halfLife: halfLife,
+	}
+}
+
+// Value returns the value of the pre-computed VWAP
+func (d *Decayed) Value() float64 {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	return d.vwap
+}
+
+// StdDev returns the decayed volume-weighted standard deviation of the pushed prices
+func (d *Decayed) StdDev() float64 {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	return d.stdDev
+}
+
+// Bands returns the VWAP minus and plus k standard deviations
+func (d *Decayed) Bands(k float64) (lower float64, upper float64) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	return bands(d.vwap, d.stdDev, k)
+}
+
+// NPoints returns the number of data points pushed so far
+func (d *Decayed) NPoints() int {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	return d.nPts
+}
+
+// Push uses the provided price, volume and trade time to recompute the VWAP
+// The sums are decayed up to the latest trade time before adding the new data point,
+// while a data point older than the latest trade time is decayed on its own
+func (d *Decayed) Push(price float64, volume float64, t time.Time) error {
+	if err := validate(price, volume); err != nil {
+		return err
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	sumPQ, sumP2Q, sumQ := d.sumPQ, d.sumP2Q, d.sumQ
+	weight := volume
+
+	if d.nPts == 0 || t.After(d.last) {
+		decay := 1.
+		if d.nPts > 0 {
+			decay = d.decay(t.Sub(d.last))
+		}
+		sumPQ, sumP2Q, sumQ = sumPQ*decay, sumP2Q*decay, sumQ*decay
+		d.last = t
+	} else {
+		weight = volume * d.decay(d.last.Sub(t))
+	}
+
+	sumPQ += price * weight
+	sumP2Q += price * price * weight
+	sumQ += weight
+
+	// decayed sums only reach 0 when every volume is 0, or when every weight underflows
+	if sumQ == 0 {
+		return ErrZeroVolume
+	}
+
+	d.nPts++
+	d.sumPQ, d.sumP2Q, d.sumQ = sumPQ, sumP2Q, sumQ
+	d.vwap, d.stdDev = vwapStdDev(sumPQ, sumP2Q, sumQ)
+
+	return nil
+}
+
+// decay returns the factor applied to weights after elapsed time
+func (d *Decayed) decay(elapsed time.Duration) float64 {
+	return math.Exp2(-float64(elapsed) / float64(d.halfLife))
+}
@@ -0,0 +1,98 @@
+package vwap
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDecayed_Push(t *testing.T) {
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type push struct {
+		price  float64
+		volume float64
+		time   time.Time
+	}
+	tests := map[string]struct {
+		halfLife time.Duration
+		pushes   []push
+		wantErr  error
+		wantVWAP float64
+		wantNPts int
+	}{
+		"it should return division by 0 error": {
+			halfLife: time.Minute,
+			pushes:   []push{{5, 0, t0}},
+			wantErr:  ErrZeroVolume,
+		},
+		"it should weigh simultaneous trades by their volume only": {
+			halfLife: time.Minute,
+			pushes:   []push{{5, 2, t0}, {4, 5, t0}},
+			wantVWAP: 30. / 7.,
+			wantNPts: 2,
+		},
+		"it should halve the weight of a trade after one half-life": {
+			halfLife: time.Minute,
+			// (5*2*0.5 + 4*1) / (2*0.5 + 1) = 9 / 2
+			pushes:   []push{{5, 2, t0}, {4, 1, t0.Add(time.Minute)}},
+			wantVWAP: 4.5,
+			wantNPts: 2,
+		},
+		"it should decay a trade older than the latest one on its own": {
+			halfLife: time.Minute,
+			pushes:   []push{{4, 1, t0.Add(time.Minute)}, {5, 2, t0}},
+			wantVWAP: 4.5,
+			wantNPts: 2,
+		},
+		"it should fall back to the default half-life": {
+			halfLife: 0,
+			// (5*2*0.5 + 4*1) / (2*0.5 + 1) = 9 / 2
+			pushes:   []push{{5, 2, t0}, {4, 1, t0.Add(defaultHalfLife)}},
+			wantVWAP: 4.5,
+			wantNPts: 2,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := NewDecayed(tt.halfLife)
+
+			var err error
+			for _, p := range tt.pushes {
+				err = d.Push(p.price, p.volume, p.time)
+			}
+
+			if err != tt.wantErr {
+				t.Errorf("Push() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := d.Value(); math.Abs(got-tt.wantVWAP) > 1e-12 {
+				t.Errorf("Value() = %v, want %v", got, tt.wantVWAP)
+			}
+			if got := d.NPoints(); got != tt.wantNPts {
+				t.Errorf("NPoints() = %v, want %v", got, tt.wantNPts)
+			}
+		})
+	}
+}
+
+func TestDecayed_Push_does_not_jump(t *testing.T) {
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := NewDecayed(time.Minute)
+
+	// a large trade followed by small ones fades away smoothly rather than falling off at once
+	_ = d.Push(100, 1000, t0)
+	prev := d.Value()
+	for i := 1; i <= 600; i++ {
+		_ = d.Push(10, 1, t0.Add(time.Duration(i)*time.Second))
+
+		got := d.Value()
+		if got > prev {
+			t.Fatalf("Value() after %d seconds = %v, should keep decreasing from %v", i, got, prev)
+		}
+		prev = got
+	}
+
+	if math.Abs(prev-10) > 2 {
+		t.Errorf("Value() after 10 half-lives = %v, want close to 10", prev)
+	}
+}
@@ -0,0 +1,162 @@
+package vwap
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Decimal is used to compute the VWAP value from a list of data points using exact
+// decimal arithmetic, so the sums of PQ and Q never drift regardless of the number
+// of data points pushed in and falling off
+type Decimal struct {
+	mux    *sync.Mutex
+	window *window
+
+	dataPts ring
+	sumPQ   *big.Rat
+	sumQ    *big.Rat
+	vwap    *big.Rat
+}
+
+// NewDecimal creates a new Decimal VWAP computed from the last maxPts data points
+func NewDecimal(maxPts int) *Decimal {
+	if maxPts < 1 {
+		maxPts = defaultMaxDataPoints
+	}
+
+	return newDecimal(Window{MaxPts: maxPts}, maxPts)
+}
+
+// NewDecimalTimeWindow creates a new Decimal VWAP which only keeps the data points whose
+// trade time falls within the given window. When window is less than or equal to 0,
+// the VWAP falls back to a window of defaultMaxDataPoints data points
+func NewDecimalTimeWindow(window time.Duration) *Decimal {
+	if window <= 0 {
+		return NewDecimal(defaultMaxDataPoints)
+	}
+
+	return newDecimal(Window{Duration: window}, defaultMaxDataPoints)
+}
+
+// newDecimal creates a new Decimal VWAP computed over the given window, its buffer
+// holding capacity data points before growing
+func newDecimal(spec Window, capacity int) *Decimal {
+	return &Decimal{
+		mux:     &sync.Mutex{},
+		window:  newWindow(spec),
+		dataPts: newRing(capacity),
+		sumPQ:   new(big.Rat),
+		sumQ:    new(big.Rat),
+		vwap:    new(big.Rat),
+	}
+}
+
+// Value returns the value of the pre-computed VWAP, rounded to the nearest float64
+func (d *Decimal) Value() float64 {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	f, _ := d.vwap.Float64()
+	return f
+}
+
+// DecimalValue returns the exact value of the pre-computed VWAP as a decimal string
+// rounded to prec digits after the decimal point
+func (d *Decimal) DecimalValue(prec int) string {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	return d.vwap.FloatString(prec)
+}
+
+// NPoints returns the number of data points currently held by the VWAP
+func (d *Decimal) NPoints() int {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	return d.dataPts.len()
+}
+
+// Oldest returns the trade time of the oldest data point held by the VWAP, or zero when there is none
+func (d *Decimal) Oldest() time.Time {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	if d.dataPts.len() == 0 {
+		return time.Time{}
+	}
+	return d.dataPts.at(0).time
+}
+
+// Push converts the provided price and volume to their shortest decimal representation
+// and uses them to recompute the VWAP. See PushDecimal
+func (d *Decimal) Push(price float64, volume float64, t time.Time) error {
+	return d.PushDecimal(
+		strconv.FormatFloat(price, 'f', -1, 64),
+		strconv.FormatFloat(volume, 'f', -1, 64),
+		t,
+	)
+}
+
+// PushDecimal uses the provided decimal price and volume strings, as sent by the exchange,
+// and the trade time to recompute the VWAP
+func (d *Decimal) PushDecimal(price string, volume string, t time.Time) error {
+	pt, err := newDecimalPoint(price, volume)
+	if err != nil {
+		return err
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	sumPQ := new(big.Rat).Add(d.sumPQ, pt.pq)
+	sumQ := new(big.Rat).Add(d.sumQ, pt.volume)
+
+	// we recompute the sums of PQ and Q without the data points falling off
+	nEvict := d.window.nEvictable(&d.dataPts, t)
+	for i := 0; i < nEvict; i++ {
+		evicted := d.dataPts.at(i).exact
+		sumPQ.Sub(sumPQ, evicted.pq)
+		sumQ.Sub(sumQ, evicted.volume)
+	}
+
+	// sums are exact, so the sum of volumes is 0 only when every volume in the window is 0
+	if sumQ.Sign() == 0 {
+		return ErrZeroVolume
+	}
+
+	d.dataPts.drop(nEvict)
+	d.dataPts.push(dataPoint{time: t, exact: pt})
+	d.sumPQ = sumPQ
+	d.sumQ = sumQ
+	d.vwap = new(big.Rat).Quo(sumPQ, sumQ)
+
+	return nil
+}
+
+// decimalPoint holds the exact volume and price times volume of a data point used by Decimal
+// to compute the final value
+type decimalPoint struct {
+	volume *big.Rat
+	pq     *big.Rat
+}
+
+func newDecimalPoint(price string, volume string) (*decimalPoint, error) {
+	p, ok := new(big.Rat).SetString(price)
+	if !ok || p.Sign() < 0 {
+		return nil, fmt.Errorf("%w '%s'", ErrInvalidPrice, price)
+	}
+
+	q, ok := new(big.Rat).SetString(volume)
+	if !ok || q.Sign() < 0 {
+		return nil, fmt.Errorf("%w '%s'", ErrInvalidVolume, volume)
+	}
+
+	return &decimalPoint{
+		volume: q,
+		pq:     p.Mul(p, q),
+	}, nil
+}
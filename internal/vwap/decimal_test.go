@@ -0,0 +1,130 @@
+package vwap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecimal_PushDecimal(t *testing.T) {
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type push struct {
+		price  string
+		volume string
+		time   time.Time
+	}
+	tests := map[string]struct {
+		vwap       *Decimal
+		pushes     []push
+		wantErrMsg string
+		wantValue  string
+		wantNPts   int
+	}{
+		"it should return division by 0 error": {
+			vwap:       NewDecimal(0),
+			pushes:     []push{{"5", "0", t0}},
+			wantErrMsg: ErrZeroVolume.Error(),
+			wantValue:  "0.000000",
+			wantNPts:   0,
+		},
+		"it should return an error for an invalid price": {
+			vwap:       NewDecimal(0),
+			pushes:     []push{{"not-a-number", "1", t0}},
+			wantErrMsg: "invalid price 'not-a-number'",
+			wantValue:  "0.000000",
+			wantNPts:   0,
+		},
+		"it should return an error for an invalid volume": {
+			vwap:       NewDecimal(0),
+			pushes:     []push{{"1", "not-a-number", t0}},
+			wantErrMsg: "invalid volume 'not-a-number'",
+			wantValue:  "0.000000",
+			wantNPts:   0,
+		},
+		"it should return an error for a negative volume": {
+			vwap:       NewDecimal(0),
+			pushes:     []push{{"1", "-0.5", t0}},
+			wantErrMsg: "invalid volume '-0.5'",
+			wantValue:  "0.000000",
+			wantNPts:   0,
+		},
+		"it should recompute without the 1st data point when reaching maxPts": {
+			vwap: NewDecimal(2),
+			pushes: []push{
+				{"5", "2", t0},
+				{"4", "5", t0},
+				{"3", "1", t0},
+			},
+			wantValue: "3.833333", // ((4*5) + (3*1)) / (5 + 1) = 23 / 6
+			wantNPts:  2,
+		},
+		"it should evict every data point older than the window": {
+			vwap: NewDecimalTimeWindow(time.Minute),
+			pushes: []push{
+				{"5", "2", t0},
+				{"4", "5", t0.Add(10 * time.Second)},
+				{"3", "1", t0.Add(65 * time.Second)},
+			},
+			wantValue: "3.833333",
+			wantNPts:  2,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var err error
+			for _, p := range tt.pushes {
+				err = tt.vwap.PushDecimal(p.price, p.volume, p.time)
+			}
+
+			if tt.wantErrMsg != "" {
+				if err == nil || err.Error() != tt.wantErrMsg {
+					t.Errorf("PushDecimal() error = %v, wantErrMsg %s", err, tt.wantErrMsg)
+				}
+			} else if err != nil {
+				t.Errorf("PushDecimal() unexpected error = %v", err)
+			}
+
+			if got := tt.vwap.DecimalValue(6); got != tt.wantValue {
+				t.Errorf("DecimalValue() = %s, want %s", got, tt.wantValue)
+			}
+			if got := tt.vwap.NPoints(); got != tt.wantNPts {
+				t.Errorf("NPoints() = %d, want %d", got, tt.wantNPts)
+			}
+		})
+	}
+}
+
+func TestDecimal_PushDecimal_does_not_drift(t *testing.T) {
+	d := NewDecimal(3)
+
+	// pushing a large trade followed by many small ones leaves float64 sums with
+	// residual rounding errors once the large trade falls off, but not decimal sums
+	prices := []string{"48123.45", "0.01", "0.02", "0.03"}
+	volumes := []string{"1234.56789", "0.1", "0.2", "0.3"}
+	for i := 0; i < 10000; i++ {
+		if err := d.PushDecimal(prices[i%4], volumes[i%4], time.Time{}); err != nil {
+			t.Fatalf("PushDecimal() unexpected error = %v", err)
+		}
+	}
+
+	// the last 3 data points are 0.01*0.1, 0.02*0.2, 0.03*0.3 => 0.014 / 0.6
+	want := "0.023333333333"
+	if got := d.DecimalValue(12); got != want {
+		t.Errorf("DecimalValue() = %s, want %s", got, want)
+	}
+}
+
+func TestDecimal_Push(t *testing.T) {
+	d := NewDecimal(0)
+
+	if err := d.Push(0.1, 0.3, time.Time{}); err != nil {
+		t.Fatalf("Push() unexpected error = %v", err)
+	}
+
+	if got := d.DecimalValue(20); got != "0.10000000000000000000" {
+		t.Errorf("DecimalValue() = %s, want %s", got, "0.10000000000000000000")
+	}
+	if got := d.Value(); got != 0.1 {
+		t.Errorf("Value() = %v, want %v", got, 0.1)
+	}
+}
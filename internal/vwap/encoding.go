@@ -0,0 +1,489 @@
+package vwap
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// encodingVersion is the version of the binary and JSON encodings of VWAP
+// It must be bumped whenever the layout of either encoding changes, while
+// decoding keeps supporting the previous versions
+//
+// Version 2 adds the volume and the trimmed volume of volume windows
+const encodingVersion = 2
+
+// maxRestoredPts bounds the number of data points of a restored window, so that corrupted
+// data cannot make the VWAP allocate an unbounded buffer
+const maxRestoredPts = 1 << 24
+
+var errTruncated = errors.New("truncated data")
+
+// state is the encoded state of a VWAP: its windows with their sums, and the data points
+// of the buffer from the oldest to the newest. Pre-computed values are not encoded, they are
+// derived from the sums when decoding
+type state struct {
+	Version        int           `json:"version"`
+	RecomputeEvery int           `json:"recompute_every"`
+	NPushes        int           `json:"n_pushes"`
+	Windows        []windowState `json:"windows"`
+	Points         []pointState  `json:"points"`
+}
+
+type windowState struct {
+	Name     string        `json:"name"`
+	MaxPts   int           `json:"max_pts"`
+	Duration time.Duration `json:"duration"`
+	Volume   float64       `json:"volume"`
+	Start    int           `json:"start"`
+	Trimmed  float64       `json:"trimmed"`
+	NQ       int           `json:"n_q"`
+	SumPQ    sumState      `json:"sum_pq"`
+	SumP2Q   sumState      `json:"sum_p2q"`
+	SumQ     sumState      `json:"sum_q"`
+	Sides    [3]sideState  `json:"sides"`
+}
+
+type sideState struct {
+	SumPQ sumState `json:"sum_pq"`
+	SumQ  sumState `json:"sum_q"`
+	NQ    int      `json:"n_q"`
+}
+
+type sumState struct {
+	Sum float64 `json:"sum"`
+	C   float64 `json:"c"`
+}
+
+func newSumState(n neumaier) sumState {
+	return sumState{Sum: n.sum, C: n.c}
+}
+
+func (s sumState) neumaier() neumaier {
+	return neumaier{sum: s.Sum, c: s.C}
+}
+
+// valid returns whether the sum and its compensation are finite
+func (s sumState) valid() bool {
+	return !math.IsNaN(s.Sum) && !math.IsInf(s.Sum, 0) && !math.IsNaN(s.C) && !math.IsInf(s.C, 0)
+}
+
+type pointState struct {
+	Price  float64   `json:"price"`
+	Volume float64   `json:"volume"`
+	Time   time.Time `json:"time"`
+	Side   Side      `json:"side"`
+}
+
+// MarshalBinary encodes the windows, sums and buffered data points of the VWAP, so it can be
+// restored with UnmarshalBinary. Numbers are big-endian, in the following layout:
+//
+//	version         uint8
+//	recomputeEvery  int64
+//	nPushes         int64
+//	windows         uint32 count, then per window:
+//	                  name (uint32 length, bytes), maxPts int64, duration int64, volume float64,
+//	                  start int64, trimmed float64, nQ int64,
+//	                  sumPQ, sumP2Q, sumQ, then unknown, buy and sell sides (sumPQ, sumQ, nQ int64)
+//	points          uint32 count, then per data point:
+//	                  price float64, volume float64, time (unix seconds int64, nanoseconds uint32), side uint8
+//
+// where every sum is its value and its compensation as float64
+func (v *VWAP) MarshalBinary() ([]byte, error) {
+	s := v.state()
+
+	e := &encoder{}
+	e.uint8(uint8(s.Version))
+	e.int64(int64(s.RecomputeEvery))
+	e.int64(int64(s.NPushes))
+
+	e.uint32(uint32(len(s.Windows)))
+	for _, w := range s.Windows {
+		e.uint32(uint32(len(w.Name)))
+		e.buf = append(e.buf, w.Name...)
+		e.int64(int64(w.MaxPts))
+		e.int64(int64(w.Duration))
+		e.float64(w.Volume)
+		e.int64(int64(w.Start))
+		e.float64(w.Trimmed)
+		e.int64(int64(w.NQ))
+		e.sum(w.SumPQ)
+		e.sum(w.SumP2Q)
+		e.sum(w.SumQ)
+		for _, side := range w.Sides {
+			e.sum(side.SumPQ)
+			e.sum(side.SumQ)
+			e.int64(int64(side.NQ))
+		}
+	}
+
+	e.uint32(uint32(len(s.Points)))
+	for _, pt := range s.Points {
+		e.float64(pt.Price)
+		e.float64(pt.Volume)
+		e.int64(pt.Time.Unix())
+		e.uint32(uint32(pt.Time.Nanosecond()))
+		e.uint8(uint8(pt.Side))
+	}
+
+	return e.buf, nil
+}
+
+// UnmarshalBinary restores the VWAP from data encoded by MarshalBinary, replacing its windows,
+// sums and data points. Trade times are restored in UTC. Percentiles are not encoded: the VWAP keeps
+// computing the ones it was created with, from the restored data points
+func (v *VWAP) UnmarshalBinary(data []byte) error {
+	d := &decoder{buf: data}
+	s := state{}
+
+	s.Version = int(d.uint8())
+	if d.err == nil && (s.Version < 1 || s.Version > encodingVersion) {
+		return fmt.Errorf("unmarshal vwap: unsupported version %d", s.Version)
+	}
+	s.RecomputeEvery = int(d.int64())
+	s.NPushes = int(d.int64())
+
+	nWindows := d.count(minWindowSize)
+	for i := 0; i < nWindows && d.err == nil; i++ {
+		w := windowState{}
+		w.Name = string(d.bytes(d.count(1)))
+		w.MaxPts = int(d.int64())
+		w.Duration = time.Duration(d.int64())
+		if s.Version >= 2 {
+			w.Volume = d.float64()
+		}
+		w.Start = int(d.int64())
+		if s.Version >= 2 {
+			w.Trimmed = d.float64()
+		}
+		w.NQ = int(d.int64())
+		w.SumPQ = d.sum()
+		w.SumP2Q = d.sum()
+		w.SumQ = d.sum()
+		for j := range w.Sides {
+			w.Sides[j].SumPQ = d.sum()
+			w.Sides[j].SumQ = d.sum()
+			w.Sides[j].NQ = int(d.int64())
+		}
+		s.Windows = append(s.Windows, w)
+	}
+
+	nPoints := d.count(pointSize)
+	for i := 0; i < nPoints && d.err == nil; i++ {
+		pt := pointState{}
+		pt.Price = d.float64()
+		pt.Volume = d.float64()
+		sec := d.int64()
+		nsec := d.uint32()
+		pt.Time = time.Unix(sec, int64(nsec)).UTC()
+		pt.Side = Side(d.uint8())
+		s.Points = append(s.Points, pt)
+	}
+
+	if d.err != nil {
+		return fmt.Errorf("unmarshal vwap: %w", d.err)
+	}
+	if len(d.buf) > 0 {
+		return fmt.Errorf("unmarshal vwap: %d unexpected trailing bytes", len(d.buf))
+	}
+
+	return v.restore(s)
+}
+
+// MarshalJSON encodes the same state as MarshalBinary as a JSON object
+func (v *VWAP) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.state())
+}
+
+// UnmarshalJSON restores the VWAP from data encoded by MarshalJSON, replacing its windows,
+// sums and data points
+func (v *VWAP) UnmarshalJSON(data []byte) error {
+	s := state{}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("unmarshal vwap: %w", err)
+	}
+	if s.Version < 1 || s.Version > encodingVersion {
+		return fmt.Errorf("unmarshal vwap: unsupported version %d", s.Version)
+	}
+
+	return v.restore(s)
+}
+
+// validate returns an error when the max points, counts or sums of the window are invalid
+func (s windowState) validate() error {
+	if s.MaxPts > maxRestoredPts {
+		return fmt.Errorf("max points %d above %d", s.MaxPts, maxRestoredPts)
+	}
+
+	if s.NQ < 0 {
+		return fmt.Errorf("negative count %d", s.NQ)
+	}
+	for _, side := range s.Sides {
+		if side.NQ < 0 {
+			return fmt.Errorf("negative side count %d", side.NQ)
+		}
+	}
+
+	sums := []sumState{s.SumPQ, s.SumP2Q, s.SumQ}
+	for _, side := range s.Sides {
+		sums = append(sums, side.SumPQ, side.SumQ)
+	}
+	for _, sum := range sums {
+		if !sum.valid() {
+			return errors.New("non-finite sum")
+		}
+	}
+
+	return nil
+}
+
+// validateCounts returns an error when the counts of data points holding a volume, of the window and of its sides,
+// differ from the ones of the data points it holds
+func (s windowState) validateCounts(pts []pointState) error {
+	nQ := 0
+	var sides [3]int
+	for _, pt := range pts {
+		if pt.Volume != 0 {
+			nQ++
+			sides[pt.Side]++
+		}
+	}
+
+	if s.NQ != nQ {
+		return fmt.Errorf("count %d of %d data points with a volume", s.NQ, nQ)
+	}
+	for i, side := range s.Sides {
+		if side.NQ != sides[i] {
+			return fmt.Errorf("side count %d of %d data points with a volume", side.NQ, sides[i])
+		}
+	}
+
+	return nil
+}
+
+// state returns a copy of the state of the VWAP
+func (v *VWAP) state() state {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	s := state{
+		Version:        encodingVersion,
+		RecomputeEvery: v.recomputeEvery,
+		NPushes:        v.nPushes,
+		Windows:        make([]windowState, 0, len(v.windows)),
+		Points:         make([]pointState, 0, v.dataPts.len()),
+	}
+
+	for _, w := range v.windows {
+		ws := windowState{
+			Name:     w.spec.Name,
+			MaxPts:   w.spec.MaxPts,
+			Duration: w.spec.Duration,
+			Volume:   w.spec.Volume,
+			Start:    w.start,
+			Trimmed:  w.trimmed,
+			NQ:       w.nQ,
+			SumPQ:    newSumState(w.sumPQ),
+			SumP2Q:   newSumState(w.sumP2Q),
+			SumQ:     newSumState(w.sumQ),
+		}
+		for i, side := range w.sides {
+			ws.Sides[i] = sideState{SumPQ: newSumState(side.sumPQ), SumQ: newSumState(side.sumQ), NQ: side.nQ}
+		}
+		s.Windows = append(s.Windows, ws)
+	}
+
+	for i := 0; i < v.dataPts.len(); i++ {
+		pt := v.dataPts.at(i)
+		s.Points = append(s.Points, pointState{Price: pt.price, Volume: pt.volume, Time: pt.time, Side: pt.side})
+	}
+
+	return s
+}
+
+// restore validates the given state and replaces the state of the VWAP with it
+// A zero VWAP can be restored
+func (v *VWAP) restore(s state) error {
+	if len(s.Windows) == 0 {
+		return errors.New("unmarshal vwap: no window")
+	}
+
+	for i, pt := range s.Points {
+		if pt.Side < SideUnknown || pt.Side > SideSell {
+			return fmt.Errorf("unmarshal vwap: invalid side %d", pt.Side)
+		}
+		if err := validate(pt.Price, pt.Volume); err != nil {
+			return fmt.Errorf("unmarshal vwap: data point %d: %w", i, err)
+		}
+		if i > 0 && pt.Time.Before(s.Points[i-1].Time) {
+			return fmt.Errorf("unmarshal vwap: data point %d traded before the previous one", i)
+		}
+	}
+
+	capacity := len(s.Points)
+	ws := make([]*window, 0, len(s.Windows))
+	for _, spec := range s.Windows {
+		if spec.Start < 0 || spec.Start > len(s.Points) {
+			return fmt.Errorf("unmarshal vwap: window start %d out of %d data points", spec.Start, len(s.Points))
+		}
+		if err := spec.validate(); err != nil {
+			return fmt.Errorf("unmarshal vwap: window %q: %w", spec.Name, err)
+		}
+		if err := spec.validateCounts(s.Points[spec.Start:]); err != nil {
+			return fmt.Errorf("unmarshal vwap: window %q: %w", spec.Name, err)
+		}
+
+		w := newWindow(Window{Name: spec.Name, MaxPts: spec.MaxPts, Duration: spec.Duration, Volume: spec.Volume})
+		if w.spec.MaxPts > 0 && len(s.Points)-spec.Start > w.spec.MaxPts {
+			return fmt.Errorf("unmarshal vwap: window %q holds %d data points above its %d max points",
+				spec.Name, len(s.Points)-spec.Start, w.spec.MaxPts)
+		}
+		if len(v.percentiles) > 0 {
+			w.stats = newOrderStats()
+		}
+		w.start = spec.Start
+		w.trimmed = spec.Trimmed
+		w.nQ = spec.NQ
+		w.sumPQ = spec.SumPQ.neumaier()
+		w.sumP2Q = spec.SumP2Q.neumaier()
+		w.sumQ = spec.SumQ.neumaier()
+		for i, side := range spec.Sides {
+			w.sides[i] = sideSums{sumPQ: side.SumPQ.neumaier(), sumQ: side.SumQ.neumaier(), nQ: side.NQ}
+		}
+		if w.nQ > 0 {
+			w.update()
+		}
+
+		if w.spec.MaxPts > capacity {
+			capacity = w.spec.MaxPts
+		}
+		ws = append(ws, w)
+	}
+	if capacity == 0 {
+		capacity = defaultMaxDataPoints
+	}
+
+	buf := newRing(capacity)
+	for _, pt := range s.Points {
+		buf.push(newDataPoint(pt.Price, pt.Volume, pt.Time, pt.Side))
+	}
+	for _, w := range ws {
+		w.rebuildStats(&buf)
+	}
+
+	if v.mux == nil {
+		v.mux = &sync.Mutex{}
+	}
+
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	v.recomputeEvery = s.RecomputeEvery
+	v.nPushes = s.NPushes
+	v.dataPts = buf
+	v.windows = ws
+
+	return nil
+}
+
+const (
+	// minWindowSize is the size of an encoded window with an empty name, in the first version
+	minWindowSize = 4 + 4*8 + 3*2*8 + 3*(2*2*8+8)
+	// pointSize is the size of an encoded data point
+	pointSize = 8 + 8 + 8 + 4 + 1
+)
+
+// encoder appends big-endian numbers to a buffer
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint8(x uint8) {
+	e.buf = append(e.buf, x)
+}
+
+func (e *encoder) uint32(x uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], x)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) int64(x int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(x))
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) float64(x float64) {
+	e.int64(int64(math.Float64bits(x)))
+}
+
+func (e *encoder) sum(s sumState) {
+	e.float64(s.Sum)
+	e.float64(s.C)
+}
+
+// decoder consumes big-endian numbers from a buffer, and returns zeros once
+// the buffer is too short, recording errTruncated
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.buf) < n {
+		d.err = errTruncated
+		return nil
+	}
+
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) uint8() uint8 {
+	if b := d.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.bytes(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) float64() float64 {
+	return math.Float64frombits(uint64(d.int64()))
+}
+
+func (d *decoder) sum() sumState {
+	return sumState{Sum: d.float64(), C: d.float64()}
+}
+
+// count reads the number of elements that follow, each at least size bytes long,
+// so that a corrupted count cannot make the decoder allocate more than the buffer holds
+func (d *decoder) count(size int) int {
+	n := int(d.uint32())
+	if d.err == nil && n > len(d.buf)/size {
+		d.err = errTruncated
+		return 0
+	}
+	return n
+}
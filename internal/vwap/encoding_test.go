@@ -0,0 +1,289 @@
+package vwap
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// encodings returns the round-trip functions of the binary and JSON encodings of VWAP
+func encodings() map[string]func(v *VWAP) (*VWAP, error) {
+	return map[string]func(v *VWAP) (*VWAP, error){
+		"binary": func(v *VWAP) (*VWAP, error) {
+			data, err := v.MarshalBinary()
+			if err != nil {
+				return nil, err
+			}
+			restored := &VWAP{}
+			return restored, restored.UnmarshalBinary(data)
+		},
+		"json": func(v *VWAP) (*VWAP, error) {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			restored := &VWAP{}
+			return restored, json.Unmarshal(data, restored)
+		},
+	}
+}
+
+func TestVWAP_encoding_round_trip(t *testing.T) {
+	t0 := time.Date(2022, 1, 2, 15, 4, 5, 123456789, time.UTC)
+
+	tests := map[string]func() *VWAP{
+		"it should restore an empty VWAP": func() *VWAP {
+			return New(10)
+		},
+		"it should restore a VWAP bounded by a number of data points": func() *VWAP {
+			return New(10, WithRecomputeEvery(7))
+		},
+		"it should restore a VWAP bounded by a time window": func() *VWAP {
+			return NewTimeWindow(time.Minute)
+		},
+		"it should restore every named window": func() *VWAP {
+			return NewWindows([]Window{
+				{Name: "5", MaxPts: 5},
+				{Name: "1m", Duration: time.Minute},
+				{Name: "30", MaxPts: 30},
+				{Name: "10v", Volume: 10},
+			})
+		},
+	}
+	for name, newVWAP := range tests {
+		for encoding, roundTrip := range encodings() {
+			t.Run(name+" with the "+encoding+" encoding", func(t *testing.T) {
+				v := newVWAP()
+				for i := 0; i < 25; i++ {
+					price := 100 + math.Sin(float64(i))
+					_ = v.PushSide(price, float64(i%4)+0.5, t0.Add(time.Duration(i)*5*time.Second), Side(i%3))
+				}
+
+				restored, err := roundTrip(v)
+				if err != nil {
+					t.Fatalf("round trip unexpected error = %v", err)
+				}
+				if !reflect.DeepEqual(restored.Windows(), v.Windows()) {
+					t.Errorf("Windows() = %v, want %v", restored.Windows(), v.Windows())
+				}
+				if restored.recomputeEvery != v.recomputeEvery || restored.nPushes != v.nPushes {
+					t.Errorf("restored recomputeEvery, nPushes = %d, %d, want %d, %d",
+						restored.recomputeEvery, restored.nPushes, v.recomputeEvery, v.nPushes)
+				}
+
+				// the restored VWAP keeps computing the same values as the original one
+				for i := 25; i < 50; i++ {
+					price := 100 + math.Cos(float64(i))
+					tt := t0.Add(time.Duration(i) * 5 * time.Second)
+					_ = v.PushSide(price, float64(i%5)+0.5, tt, Side(i%3))
+					_ = restored.PushSide(price, float64(i%5)+0.5, tt, Side(i%3))
+				}
+				if !reflect.DeepEqual(restored.Windows(), v.Windows()) {
+					t.Errorf("Windows() after pushes = %v, want %v", restored.Windows(), v.Windows())
+				}
+			})
+		}
+	}
+}
+
+func TestVWAP_UnmarshalBinary_percentiles(t *testing.T) {
+	v := NewWindows([]Window{{Name: "5", MaxPts: 5}, {Name: "10v", Volume: 10}}, WithPercentiles(5, 95))
+	for i := 0; i < 25; i++ {
+		_ = v.Push(100+math.Sin(float64(i)), float64(i%4)+0.5, time.Time{})
+	}
+
+	data, err := v.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() unexpected error = %v", err)
+	}
+
+	// the restored VWAP keeps its own percentiles, rebuilt from the restored data points
+	restored := NewWindows(nil, WithPercentiles(5, 95))
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary() unexpected error = %v", err)
+	}
+	if !reflect.DeepEqual(restored.Windows(), v.Windows()) {
+		t.Errorf("Windows() = %v, want %v", restored.Windows(), v.Windows())
+	}
+}
+
+func TestVWAP_MarshalJSON(t *testing.T) {
+	v := NewWindows([]Window{{Name: "2", MaxPts: 2}})
+	_ = v.PushSide(2, 1, time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC), SideBuy)
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("MarshalJSON() unexpected error = %v", err)
+	}
+
+	want := `{"version":2,"recompute_every":10000,"n_pushes":1,"windows":[{"name":"2","max_pts":2,"duration":0,` +
+		`"volume":0,"start":0,"trimmed":0,"n_q":1,"sum_pq":{"sum":2,"c":0},"sum_p2q":{"sum":4,"c":0},"sum_q":{"sum":1,"c":0},"sides":[` +
+		`{"sum_pq":{"sum":0,"c":0},"sum_q":{"sum":0,"c":0},"n_q":0},` +
+		`{"sum_pq":{"sum":2,"c":0},"sum_q":{"sum":1,"c":0},"n_q":1},` +
+		`{"sum_pq":{"sum":0,"c":0},"sum_q":{"sum":0,"c":0},"n_q":0}]}],` +
+		`"points":[{"price":2,"volume":1,"time":"2022-01-02T00:00:00Z","side":1}]}`
+	if string(data) != want {
+		t.Errorf("MarshalJSON() = %s, want %s", data, want)
+	}
+}
+
+func TestVWAP_Unmarshal_version_1(t *testing.T) {
+	v := NewWindows([]Window{{Name: "2", MaxPts: 2}})
+	_ = v.PushSide(2, 1, time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC), SideBuy)
+
+	// the first version has neither the volume nor the trimmed volume of the windows, which
+	// follow the duration and the start of the window
+	data, _ := v.MarshalBinary()
+	nameEnd := 1 + 8 + 8 + 4 + 4 + len("2")
+	durationEnd := nameEnd + 8 + 8
+	startEnd := durationEnd + 8 + 8
+	v1 := append([]byte{1}, data[1:durationEnd]...)
+	v1 = append(v1, data[durationEnd+8:startEnd]...)
+	v1 = append(v1, data[startEnd+8:]...)
+
+	fromBinary := &VWAP{}
+	if err := fromBinary.UnmarshalBinary(v1); err != nil {
+		t.Fatalf("UnmarshalBinary() unexpected error = %v", err)
+	}
+	if !reflect.DeepEqual(fromBinary.Windows(), v.Windows()) {
+		t.Errorf("UnmarshalBinary() windows = %v, want %v", fromBinary.Windows(), v.Windows())
+	}
+
+	fromJSON := &VWAP{}
+	err := json.Unmarshal([]byte(`{"version":1,"recompute_every":10000,"n_pushes":1,"windows":[{"name":"2",`+
+		`"max_pts":2,"duration":0,"start":0,"n_q":1,"sum_pq":{"sum":2,"c":0},"sum_p2q":{"sum":4,"c":0},`+
+		`"sum_q":{"sum":1,"c":0},"sides":[{"sum_pq":{"sum":0,"c":0},"sum_q":{"sum":0,"c":0},"n_q":0},`+
+		`{"sum_pq":{"sum":2,"c":0},"sum_q":{"sum":1,"c":0},"n_q":1},{"sum_pq":{"sum":0,"c":0},"sum_q":{"sum":0,"c":0},"n_q":0}]}],`+
+		`"points":[{"price":2,"volume":1,"time":"2022-01-02T00:00:00Z","side":1}]}`), fromJSON)
+	if err != nil {
+		t.Fatalf("UnmarshalJSON() unexpected error = %v", err)
+	}
+	if !reflect.DeepEqual(fromJSON.Windows(), v.Windows()) {
+		t.Errorf("UnmarshalJSON() windows = %v, want %v", fromJSON.Windows(), v.Windows())
+	}
+}
+
+func TestVWAP_UnmarshalBinary_errors(t *testing.T) {
+	v := NewWindows([]Window{{Name: "2", MaxPts: 2}})
+	_ = v.Push(2, 1, time.Time{})
+	_ = v.Push(3, 1, time.Time{})
+	data, _ := v.MarshalBinary()
+
+	// patch returns a copy of the data with the int64 at the offset replaced. Window fields are found
+	// after the 21 bytes of the header and the 5 bytes of the window name
+	const maxPtsOffset, nQOffset, sumPQOffset = 26, 66, 74
+	patch := func(offset int, x uint64) []byte {
+		patched := append([]byte{}, data...)
+		binary.BigEndian.PutUint64(patched[offset:], x)
+		return patched
+	}
+
+	tests := map[string]struct {
+		data    []byte
+		wantErr string
+	}{
+		"it should reject an unsupported version": {
+			data:    append([]byte{3}, data[1:]...),
+			wantErr: "unmarshal vwap: unsupported version 3",
+		},
+		"it should reject truncated data": {
+			data:    data[:len(data)-1],
+			wantErr: "unmarshal vwap: truncated data",
+		},
+		"it should reject trailing bytes": {
+			data:    append(append([]byte{}, data...), 0),
+			wantErr: "unmarshal vwap: 1 unexpected trailing bytes",
+		},
+		"it should reject empty data": {
+			data:    nil,
+			wantErr: "unmarshal vwap: truncated data",
+		},
+		"it should reject a huge window": {
+			data:    patch(maxPtsOffset, 1<<62),
+			wantErr: `unmarshal vwap: window "2": max points 4611686018427387904 above 16777216`,
+		},
+		"it should reject a window holding more data points than its max": {
+			data:    patch(maxPtsOffset, 1),
+			wantErr: `unmarshal vwap: window "2" holds 2 data points above its 1 max points`,
+		},
+		"it should reject a negative count": {
+			data:    patch(nQOffset, uint64(math.MaxUint64)),
+			wantErr: `unmarshal vwap: window "2": negative count -1`,
+		},
+		"it should reject a NaN sum": {
+			data:    patch(sumPQOffset, math.Float64bits(math.NaN())),
+			wantErr: `unmarshal vwap: window "2": non-finite sum`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			restored := New(10)
+			err := restored.UnmarshalBinary(tt.data)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("UnmarshalBinary() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// a failed restore leaves the VWAP untouched
+			if restored.NPoints() != 0 {
+				t.Errorf("NPoints() = %d, want 0", restored.NPoints())
+			}
+		})
+	}
+}
+
+func TestVWAP_UnmarshalJSON_errors(t *testing.T) {
+	tests := map[string]struct {
+		data    string
+		wantErr string
+	}{
+		"it should reject an unsupported version": {
+			data:    `{"version":3,"windows":[{"max_pts":2}]}`,
+			wantErr: "unmarshal vwap: unsupported version 3",
+		},
+		"it should reject a VWAP without window": {
+			data:    `{"version":1}`,
+			wantErr: "unmarshal vwap: no window",
+		},
+		"it should reject a window starting after the newest data point": {
+			data:    `{"version":1,"windows":[{"max_pts":2,"start":1}]}`,
+			wantErr: "unmarshal vwap: window start 1 out of 0 data points",
+		},
+		"it should reject an invalid side": {
+			data:    `{"version":1,"windows":[{"max_pts":2}],"points":[{"price":1,"volume":1,"side":3}]}`,
+			wantErr: "unmarshal vwap: invalid side 3",
+		},
+		"it should reject an invalid price": {
+			data:    `{"version":1,"windows":[{"max_pts":2,"n_q":1}],"points":[{"price":-1e300,"volume":1}]}`,
+			wantErr: "unmarshal vwap: data point 0: invalid price -1e+300",
+		},
+		"it should reject an invalid volume": {
+			data:    `{"version":1,"windows":[{"max_pts":2,"n_q":1}],"points":[{"price":1,"volume":-7}]}`,
+			wantErr: "unmarshal vwap: data point 0: invalid volume -7",
+		},
+		"it should reject data points out of order": {
+			data: `{"version":1,"windows":[{"max_pts":2,"n_q":2}],"points":[` +
+				`{"price":1,"volume":1,"time":"2022-01-02T15:04:06Z"},{"price":1,"volume":1,"time":"2022-01-02T15:04:05Z"}]}`,
+			wantErr: "unmarshal vwap: data point 1 traded before the previous one",
+		},
+		"it should reject a count differing from the data points": {
+			data:    `{"version":1,"windows":[{"max_pts":2,"n_q":2}],"points":[{"price":1,"volume":1},{"price":1,"volume":0}]}`,
+			wantErr: `unmarshal vwap: window "": count 2 of 1 data points with a volume`,
+		},
+		"it should reject a side count differing from the data points": {
+			data: `{"version":1,"windows":[{"max_pts":2,"n_q":1,"sides":[{"n_q":0},{"n_q":1},{"n_q":0}]}],` +
+				`"points":[{"price":1,"volume":1,"side":0}]}`,
+			wantErr: `unmarshal vwap: window "": side count 0 of 1 data points with a volume`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := (&VWAP{}).UnmarshalJSON([]byte(tt.data))
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("UnmarshalJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package vwap
+
+import "math"
+
+type options struct {
+	recomputeEvery int
+	percentiles    []float64
+}
+
+type Option interface {
+	apply(*options)
+}
+
+func newOptions(opts []Option) options {
+	options := options{
+		recomputeEvery: defaultRecomputeEvery,
+	}
+
+	for _, o := range opts {
+		o.apply(&options)
+	}
+
+	return options
+}
+
+type recomputeEveryOption struct {
+	NPushes int
+}
+
+func (r recomputeEveryOption) apply(opts *options) {
+	opts.recomputeEvery = r.NPushes
+}
+
+// WithRecomputeEvery recomputes the VWAP sums from the buffered data points every nPushes pushes,
+// discarding any residual floating point error. 0 uses the default cadence, a negative value never recomputes
+func WithRecomputeEvery(nPushes int) Option {
+	if nPushes == 0 {
+		nPushes = defaultRecomputeEvery
+	}
+	return recomputeEveryOption{NPushes: nPushes}
+}
+
+type percentilesOption struct {
+	Percentiles []float64
+}
+
+func (p percentilesOption) apply(opts *options) {
+	opts.percentiles = p.Percentiles
+}
+
+// WithPercentiles computes the volume-weighted median of every window, along with the given percentiles
+// between 0 and 100. Percentiles out of range are ignored
+func WithPercentiles(ps ...float64) Option {
+	percentiles := []float64{50}
+	for _, p := range ps {
+		if p < 0 || p > 100 || math.IsNaN(p) || containsFloat(percentiles, p) {
+			continue
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentilesOption{Percentiles: percentiles}
+}
+
+func containsFloat(fs []float64, f float64) bool {
+	for _, v := range fs {
+		if v == f {
+			return true
+		}
+	}
+	return false
+}
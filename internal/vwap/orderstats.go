@@ -0,0 +1,220 @@
+package vwap
+
+// orderStats is an order-statistics tree of the prices of a window weighted by their volume,
+// used to compute volume-weighted percentiles. It is a treap whose nodes hold every data point
+// traded at the same price, and the volume of their subtree, so that inserting and evicting data
+// points and finding a percentile take O(log n). Removed nodes are reused, so pushing data points
+// does not allocate once the tree holds the largest window
+type orderStats struct {
+	root *osNode
+	free *osNode
+	seed uint64
+}
+
+// percentileTolerance is the fraction of the total volume below which the volume of the data points
+// traded at or below a price is still considered to reach a percentile
+const percentileTolerance = 1e-9
+
+type osNode struct {
+	price  float64
+	volume float64
+	count  int
+	// sum is the volume of the subtree of the node
+	sum      float64
+	priority uint64
+	left     *osNode
+	right    *osNode
+}
+
+// volumeSum returns the volume of the subtree, which is 0 for an empty one
+func (n *osNode) volumeSum() float64 {
+	if n == nil {
+		return 0
+	}
+	return n.sum
+}
+
+// update recomputes the volume of the subtree from its children, so it does not drift
+func (n *osNode) update() {
+	if n.volume < 0 {
+		n.volume = 0
+	}
+	n.sum = n.left.volumeSum() + n.volume + n.right.volumeSum()
+}
+
+func newOrderStats() *orderStats {
+	return &orderStats{seed: 0x9e3779b97f4a7c15}
+}
+
+// add adds a data point to the tree
+func (o *orderStats) add(price float64, volume float64) {
+	o.root = o.insert(o.root, price, volume)
+}
+
+// subtract removes the given volume traded at price from the tree, along with its data point when evict is set
+func (o *orderStats) subtract(price float64, volume float64, evict bool) {
+	o.root = o.delete(o.root, price, volume, evict)
+}
+
+// reset removes every data point from the tree
+func (o *orderStats) reset() {
+	o.release(o.root)
+	o.root = nil
+}
+
+// total returns the volume of every data point of the tree
+func (o *orderStats) total() float64 {
+	return o.root.volumeSum()
+}
+
+// percentile returns the lowest price such that the data points traded at or below it hold
+// at least p percent of the volume, or 0 when the tree holds no volume
+func (o *orderStats) percentile(p float64) float64 {
+	total := o.total()
+	if total <= 0 {
+		return 0
+	}
+
+	// rounding errors on the volumes of the tree must not shift the percentile to the next price
+	target := p/100*total - total*percentileTolerance
+	best := 0.
+	for n := o.root; n != nil; {
+		left := n.left.volumeSum()
+		if left > 0 && target <= left {
+			n = n.left
+			continue
+		}
+		target -= left
+
+		if n.volume > 0 {
+			if target <= n.volume {
+				return n.price
+			}
+			best = n.price
+		}
+		target -= n.volume
+		n = n.right
+	}
+
+	// rounding errors may push the target beyond the highest price
+	return best
+}
+
+func (o *orderStats) insert(n *osNode, price float64, volume float64) *osNode {
+	if n == nil {
+		return o.newNode(price, volume)
+	}
+
+	switch {
+	case price < n.price:
+		n.left = o.insert(n.left, price, volume)
+		if n.left.priority > n.priority {
+			n = rotateRight(n)
+		}
+	case price > n.price:
+		n.right = o.insert(n.right, price, volume)
+		if n.right.priority > n.priority {
+			n = rotateLeft(n)
+		}
+	default:
+		n.volume += volume
+		n.count++
+	}
+
+	n.update()
+	return n
+}
+
+func (o *orderStats) delete(n *osNode, price float64, volume float64, evict bool) *osNode {
+	if n == nil {
+		return nil
+	}
+
+	switch {
+	case price < n.price:
+		n.left = o.delete(n.left, price, volume, evict)
+	case price > n.price:
+		n.right = o.delete(n.right, price, volume, evict)
+	default:
+		n.volume -= volume
+		if evict {
+			n.count--
+		}
+		if n.count == 0 {
+			merged := merge(n.left, n.right)
+			n.left, n.right = nil, nil
+			o.release(n)
+			return merged
+		}
+	}
+
+	n.update()
+	return n
+}
+
+// newNode returns a node holding a single data point, reusing a released node when available
+func (o *orderStats) newNode(price float64, volume float64) *osNode {
+	n := o.free
+	if n != nil {
+		o.free = n.right
+	} else {
+		n = &osNode{}
+	}
+
+	// xorshift is enough to keep the treap balanced
+	o.seed ^= o.seed << 13
+	o.seed ^= o.seed >> 7
+	o.seed ^= o.seed << 17
+
+	*n = osNode{price: price, volume: volume, count: 1, priority: o.seed}
+	n.update()
+	return n
+}
+
+// release adds the nodes of a subtree to the free list
+func (o *orderStats) release(n *osNode) {
+	if n == nil {
+		return
+	}
+
+	o.release(n.left)
+	o.release(n.right)
+	*n = osNode{right: o.free}
+	o.free = n
+}
+
+func rotateRight(n *osNode) *osNode {
+	l := n.left
+	n.left = l.right
+	n.update()
+	l.right = n
+	return l
+}
+
+func rotateLeft(n *osNode) *osNode {
+	r := n.right
+	n.right = r.left
+	n.update()
+	r.left = n
+	return r
+}
+
+// merge merges two subtrees whose prices are all lower in the first one
+func merge(a *osNode, b *osNode) *osNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	if a.priority > b.priority {
+		a.right = merge(a.right, b)
+		a.update()
+		return a
+	}
+
+	b.left = merge(a, b.left)
+	b.update()
+	return b
+}
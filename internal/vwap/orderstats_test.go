@@ -0,0 +1,130 @@
+package vwap
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// bruteForcePercentile returns the lowest price such that the data points traded at or below
+// it hold at least p percent of the volume
+func bruteForcePercentile(pts []dataPoint, p float64) float64 {
+	sorted := append([]dataPoint(nil), pts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].price < sorted[j].price })
+
+	total := 0.
+	for _, pt := range sorted {
+		total += pt.volume
+	}
+
+	cum := 0.
+	for _, pt := range sorted {
+		cum += pt.volume
+		if pt.volume > 0 && cum >= p/100*total-total*percentileTolerance {
+			return pt.price
+		}
+	}
+	return 0
+}
+
+func TestOrderStats_percentile(t *testing.T) {
+	tests := map[string]struct {
+		pts  []dataPoint
+		p    float64
+		want float64
+	}{
+		"it should return 0 without volume": {
+			pts:  []dataPoint{{price: 5, volume: 0}},
+			p:    50,
+			want: 0,
+		},
+		"it should weigh prices by their volume": {
+			pts:  []dataPoint{{price: 1, volume: 1}, {price: 2, volume: 1}, {price: 3, volume: 10}},
+			p:    50,
+			want: 3,
+		},
+		"it should return the lowest price reaching the percentile": {
+			pts:  []dataPoint{{price: 3, volume: 1}, {price: 1, volume: 1}, {price: 2, volume: 2}},
+			p:    50,
+			want: 2,
+		},
+		"it should skip prices without volume": {
+			pts:  []dataPoint{{price: 1, volume: 0}, {price: 2, volume: 1}, {price: 3, volume: 1}},
+			p:    0,
+			want: 2,
+		},
+		"it should return the highest price for the 100th percentile": {
+			pts:  []dataPoint{{price: 1, volume: 1}, {price: 3, volume: 1}, {price: 4, volume: 0}},
+			p:    100,
+			want: 3,
+		},
+		"it should merge the volumes of a same price": {
+			pts:  []dataPoint{{price: 1, volume: 1}, {price: 2, volume: 1}, {price: 1, volume: 1}},
+			p:    60,
+			want: 1,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := newOrderStats()
+			for _, pt := range tt.pts {
+				o.add(pt.price, pt.volume)
+			}
+			if got := o.percentile(tt.p); got != tt.want {
+				t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderStats_matches_brute_force(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	o := newOrderStats()
+
+	var pts []dataPoint
+	for i := 0; i < 2000; i++ {
+		// evict a random data point from time to time, so the tree grows and shrinks
+		if len(pts) > 0 && rnd.Intn(3) == 0 {
+			j := rnd.Intn(len(pts))
+			o.subtract(pts[j].price, pts[j].volume, true)
+			pts = append(pts[:j], pts[j+1:]...)
+			continue
+		}
+
+		pt := dataPoint{price: float64(rnd.Intn(50)), volume: float64(rnd.Intn(4))}
+		o.add(pt.price, pt.volume)
+		pts = append(pts, pt)
+
+		for _, p := range []float64{0, 5, 50, 95, 100} {
+			if got, want := o.percentile(p), bruteForcePercentile(pts, p); got != want {
+				t.Fatalf("step %d: percentile(%v) = %v, want %v", i, p, got, want)
+			}
+		}
+	}
+
+	for _, pt := range pts {
+		o.subtract(pt.price, pt.volume, true)
+	}
+	if o.root != nil || math.Abs(o.total()) > 0 {
+		t.Errorf("the tree should be empty once every data point is evicted")
+	}
+}
+
+func TestOrderStats_does_not_allocate(t *testing.T) {
+	o := newOrderStats()
+	for i := 0; i < 100; i++ {
+		o.add(float64(i), 1)
+	}
+
+	i := 0
+	allocs := testing.AllocsPerRun(1000, func() {
+		o.subtract(float64(i%100), 1, true)
+		o.add(float64(i%100), 1)
+		_ = o.percentile(50)
+		i++
+	})
+	if allocs != 0 {
+		t.Errorf("add and subtract allocated %v times per run, want 0", allocs)
+	}
+}
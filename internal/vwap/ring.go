@@ -0,0 +1,63 @@
+package vwap
+
+// ring is a circular buffer of data points, ordered from the oldest to the newest.
+// Once its backing array reaches the capacity needed by the VWAP window, pushing
+// and dropping data points does not allocate
+type ring struct {
+	pts  []dataPoint
+	head int
+	size int
+}
+
+// newRing creates a new ring able to hold capacity data points before growing
+func newRing(capacity int) ring {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return ring{
+		pts: make([]dataPoint, capacity),
+	}
+}
+
+// len returns the number of data points held by the ring
+func (r *ring) len() int {
+	return r.size
+}
+
+// at returns the i-th oldest data point
+func (r *ring) at(i int) dataPoint {
+	return r.pts[(r.head+i)%len(r.pts)]
+}
+
+// push appends a data point after the newest one, doubling the capacity
+// of the ring when it is full
+func (r *ring) push(pt dataPoint) {
+	if r.size == len(r.pts) {
+		r.grow()
+	}
+
+	r.pts[(r.head+r.size)%len(r.pts)] = pt
+	r.size++
+}
+
+// drop removes the n oldest data points
+func (r *ring) drop(n int) {
+	if n > r.size {
+		n = r.size
+	}
+
+	r.head = (r.head + n) % len(r.pts)
+	r.size -= n
+}
+
+// grow doubles the capacity of the ring, moving the oldest data point to index 0
+func (r *ring) grow() {
+	pts := make([]dataPoint, 2*len(r.pts))
+	for i := 0; i < r.size; i++ {
+		pts[i] = r.at(i)
+	}
+
+	r.pts = pts
+	r.head = 0
+}
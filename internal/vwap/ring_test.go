@@ -0,0 +1,75 @@
+package vwap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	pt := func(i int) dataPoint {
+		return dataPoint{price: float64(i), volume: 1}
+	}
+
+	tests := map[string]struct {
+		capacity int
+		push     int
+		drop     int
+		want     []dataPoint
+		wantCap  int
+	}{
+		"it should hold the pushed data points in order": {
+			capacity: 3,
+			push:     3,
+			want:     []dataPoint{pt(0), pt(1), pt(2)},
+			wantCap:  3,
+		},
+		"it should wrap around after dropping the oldest data points": {
+			capacity: 3,
+			push:     5,
+			drop:     2,
+			want:     []dataPoint{pt(2), pt(3), pt(4)},
+			wantCap:  3,
+		},
+		"it should grow when pushing into a full ring": {
+			capacity: 2,
+			push:     5,
+			want:     []dataPoint{pt(0), pt(1), pt(2), pt(3), pt(4)},
+			wantCap:  8,
+		},
+		"it should not drop more data points than it holds": {
+			capacity: 2,
+			push:     1,
+			drop:     3,
+			want:     []dataPoint{},
+			wantCap:  2,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := newRing(tt.capacity)
+
+			// keep the ring bounded to its capacity while pushing, the same way VWAP does
+			dropped := 0
+			for i := 0; i < tt.push; i++ {
+				if r.len() == tt.capacity && dropped < tt.drop {
+					r.drop(1)
+					dropped++
+				}
+				r.push(pt(i))
+			}
+			r.drop(tt.drop - dropped)
+
+			got := []dataPoint{}
+			for i := 0; i < r.len(); i++ {
+				got = append(got, r.at(i))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ring = %v, want %v", got, tt.want)
+			}
+			if len(r.pts) != tt.wantCap {
+				t.Errorf("capacity = %d, want %d", len(r.pts), tt.wantCap)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package vwap
+
+// Side is the side of the aggressor of a trade, i.e. the taker of the liquidity
+type Side int
+
+const (
+	SideUnknown Side = iota
+	SideBuy
+	SideSell
+)
+
+// String returns a human-readable format of the side
+func (s Side) String() string {
+	switch s {
+	case SideBuy:
+		return "buy"
+	case SideSell:
+		return "sell"
+	default:
+		return "unknown"
+	}
+}
+
+// SideValue is the pre-computed VWAP and volume of the data points of a single aggressor side
+type SideValue struct {
+	Value  float64
+	Volume float64
+}
+
+// sideSums holds the sums of the data points of a single aggressor side
+type sideSums struct {
+	sumPQ neumaier
+	sumQ  neumaier
+	nQ    int
+}
+
+func (s *sideSums) add(pt dataPoint) {
+	s.sumPQ.add(pt.price * pt.volume)
+	s.sumQ.add(pt.volume)
+	if pt.volume != 0 {
+		s.nQ++
+	}
+}
+
+func (s *sideSums) remove(pt dataPoint) {
+	s.subtract(pt.price, pt.volume)
+	if pt.volume != 0 {
+		s.nQ--
+	}
+}
+
+// subtract removes the given volume traded at price from the sums, without removing its data point
+func (s *sideSums) subtract(price float64, volume float64) {
+	s.sumPQ.add(-(price * volume))
+	s.sumQ.add(-volume)
+}
+
+// value returns the VWAP and volume of the side, or zeros when the side holds no volume
+func (s *sideSums) value() SideValue {
+	if s.nQ == 0 {
+		return SideValue{}
+	}
+
+	return SideValue{
+		Value:  s.sumPQ.value() / s.sumQ.value(),
+		Volume: s.sumQ.value(),
+	}
+}
@@ -0,0 +1,27 @@
+package vwap
+
+import "math"
+
+// neumaier is a compensated sum (Kahan-Babuska-Neumaier), which keeps track of the
+// low-order bits lost when adding floating point numbers of different magnitudes,
+// so that repeatedly adding and subtracting values does not accumulate errors
+type neumaier struct {
+	sum float64
+	c   float64
+}
+
+// add adds x to the sum
+func (n *neumaier) add(x float64) {
+	t := n.sum + x
+	if math.Abs(n.sum) >= math.Abs(x) {
+		n.c += (n.sum - t) + x
+	} else {
+		n.c += (x - t) + n.sum
+	}
+	n.sum = t
+}
+
+// value returns the compensated sum
+func (n neumaier) value() float64 {
+	return n.sum + n.c
+}
@@ -0,0 +1,39 @@
+package vwap
+
+import "testing"
+
+func TestNeumaier_add(t *testing.T) {
+	tests := map[string]struct {
+		values []float64
+		want   float64
+	}{
+		"it should sum values of similar magnitudes": {
+			values: []float64{1, 2, 3.5},
+			want:   6.5,
+		},
+		"it should keep small values added to a large one": {
+			values: []float64{1e16, 1, 1, -1e16},
+			want:   2,
+		},
+		"it should keep a large value added to small ones": {
+			values: []float64{1, 1e100, 1, -1e100},
+			want:   2,
+		},
+		"it should return 0 after subtracting every added value": {
+			values: []float64{0.1, 0.2, 0.3, -0.1, -0.2, -0.3},
+			want:   0,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			n := neumaier{}
+			for _, v := range tt.values {
+				n.add(v)
+			}
+
+			if got := n.value(); got != tt.want {
+				t.Errorf("value() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,102 +1,334 @@
 package vwap
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"sync"
+	"time"
 )
 
 const (
-	defaultMaxDataPoints = 200
+	defaultMaxDataPoints  = 200
+	defaultRecomputeEvery = 10000
 )
 
 var (
-	divBy0Err = fmt.Errorf("error calculating vwap: sum of volumes equals to 0")
+	// ErrInvalidPrice is returned when pushing a negative, NaN or infinite price
+	ErrInvalidPrice = errors.New("invalid price")
+	// ErrInvalidVolume is returned when pushing a negative, NaN or infinite volume
+	ErrInvalidVolume = errors.New("invalid volume")
+	// ErrZeroVolume is returned when pushing a data point would make the sum of volumes equal to 0
+	ErrZeroVolume = errors.New("error calculating vwap: sum of volumes equals to 0")
 )
 
 // VWAP is used to compute the VWAP value from a list of data points
+// The list is either bounded by a number of data points (see New), by a
+// time window relative to the most recent trade (see NewTimeWindow), or by
+// the last units traded (see NewVolumeWindow)
+// Several named windows can share the same list of data points (see NewWindows)
+// Data points are stored in a ring buffer, so pushing new ones does not allocate once
+// the buffer holds the largest window
+// Sums are compensated and periodically recomputed from the buffered data points (see WithRecomputeEvery),
+// so they do not drift however many data points are pushed in and fall off
+// The volume-weighted median and percentiles of the prices of every window can be computed
+// alongside (see WithPercentiles)
+// The state of a VWAP can be persisted and restored with its binary and JSON encodings (see MarshalBinary)
 type VWAP struct {
-	mux    *sync.Mutex
-	maxPts int
+	mux            *sync.Mutex
+	recomputeEvery int
+	// percentiles are the percentiles computed for every window, the median first
+	percentiles []float64
 
-	dataPts []dataPoint
-	sumPQ   float64
-	sumQ    float64
-	vwap    float64
+	dataPts ring
+	windows []*window
+	nPushes int
 }
 
 // New creates a new VWAP used to compute the VWAP from a list of data points
-func New(maxPts int) *VWAP {
+func New(maxPts int, opts ...Option) *VWAP {
 	if maxPts < 1 {
 		maxPts = defaultMaxDataPoints
 	}
 
+	return NewWindows([]Window{{MaxPts: maxPts}}, opts...)
+}
+
+// NewTimeWindow creates a new VWAP which only keeps the data points whose trade
+// time falls within the given window. When window is less than or equal to 0,
+// the VWAP falls back to a window of defaultMaxDataPoints data points
+func NewTimeWindow(window time.Duration, opts ...Option) *VWAP {
+	if window <= 0 {
+		return New(defaultMaxDataPoints, opts...)
+	}
+
+	return NewWindows([]Window{{Duration: window}}, opts...)
+}
+
+// NewVolumeWindow creates a new VWAP which only keeps the last data points whose volumes add up
+// to the given volume, trimming the volume of the oldest one. When volume is less than or equal to 0,
+// the VWAP falls back to a window of defaultMaxDataPoints data points
+func NewVolumeWindow(volume float64, opts ...Option) *VWAP {
+	if !(volume > 0) {
+		return New(defaultMaxDataPoints, opts...)
+	}
+
+	return NewWindows([]Window{{Volume: volume}}, opts...)
+}
+
+// NewWindows creates a new VWAP computing a VWAP for each of the given windows from
+// a single list of data points. Value and NPoints refer to the first window
+func NewWindows(windows []Window, opts ...Option) *VWAP {
+	if len(windows) == 0 {
+		windows = []Window{{MaxPts: defaultMaxDataPoints}}
+	}
+
+	options := newOptions(opts)
+
+	// the buffer must at least hold the largest window of data points, time windows
+	// make it grow until it holds all the data points they need
+	capacity := 0
+	ws := make([]*window, 0, len(windows))
+	for _, spec := range windows {
+		w := newWindow(spec)
+		if len(options.percentiles) > 0 {
+			w.stats = newOrderStats()
+		}
+		if w.spec.MaxPts > capacity {
+			capacity = w.spec.MaxPts
+		}
+		ws = append(ws, w)
+	}
+	if capacity == 0 {
+		capacity = defaultMaxDataPoints
+	}
+
 	return &VWAP{
-		mux:     &sync.Mutex{},
-		maxPts:  maxPts,
-		dataPts: []dataPoint{},
-		sumPQ:   0,
-		sumQ:    0,
-		vwap:    0,
+		mux:            &sync.Mutex{},
+		recomputeEvery: options.recomputeEvery,
+		percentiles:    options.percentiles,
+		dataPts:        newRing(capacity),
+		windows:        ws,
 	}
 }
 
 // Value returns the value of the pre-computed VWAP
 func (v *VWAP) Value() float64 {
-	return v.vwap
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	return v.windows[0].vwap
+}
+
+// StdDev returns the volume-weighted standard deviation of the prices held by VWAP
+func (v *VWAP) StdDev() float64 {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	return v.windows[0].stdDev
+}
+
+// Bands returns the VWAP minus and plus k standard deviations
+func (v *VWAP) Bands(k float64) (lower float64, upper float64) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	return bands(v.windows[0].vwap, v.windows[0].stdDev, k)
 }
 
 // NPoints returns the number of data points currently held by VWAP
 func (v *VWAP) NPoints() int {
-	return len(v.dataPts)
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	return v.windows[0].nPoints(&v.dataPts)
 }
 
-// Push uses the provided price and volume to recompute the VWAP
+// Median returns the volume-weighted median of the prices held by VWAP, which is the lowest
+// price such that at least half of the volume was traded at or below it. It is 0 unless
+// the VWAP computes percentiles (see WithPercentiles)
+func (v *VWAP) Median() float64 {
+	return v.Percentile(50)
+}
+
+// Percentile returns the volume-weighted p percentile of the prices held by VWAP, which is the lowest
+// price such that at least p percent of the volume was traded at or below it. It is 0 unless
+// the VWAP computes percentiles (see WithPercentiles)
+func (v *VWAP) Percentile(p float64) float64 {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	return v.windows[0].percentile(p)
+}
+
+// Windows returns the pre-computed VWAP of every window, in the order they were provided
+func (v *VWAP) Windows() []WindowValue {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	values := make([]WindowValue, 0, len(v.windows))
+	for _, w := range v.windows {
+		values = append(values, w.value(&v.dataPts, v.percentiles))
+	}
+
+	return values
+}
+
+// Side returns the pre-computed VWAP and volume of the data points of the given aggressor side
+func (v *VWAP) Side(side Side) SideValue {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	return v.windows[0].sides[side].value()
+}
+
+// Snapshot is a consistent view of the first window of a VWAP, read at once
+type Snapshot struct {
+	Value   float64
+	StdDev  float64
+	SumPQ   float64
+	SumQ    float64
+	NPoints int
+	// Oldest and Newest are the trade times of the oldest and newest data points of the window,
+	// or zero when the window is empty
+	Oldest time.Time
+	Newest time.Time
+}
+
+// Snapshot returns the pre-computed VWAP of the first window along with its sums, number of
+// data points and trade times, all read under the same lock
+func (v *VWAP) Snapshot() Snapshot {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	w := v.windows[0]
+	s := Snapshot{
+		Value:   w.vwap,
+		StdDev:  w.stdDev,
+		SumPQ:   w.sumPQ.value(),
+		SumQ:    w.sumQ.value(),
+		NPoints: w.nPoints(&v.dataPts),
+	}
+	if s.NPoints > 0 {
+		s.Oldest = v.dataPts.at(w.start).time
+		s.Newest = v.dataPts.at(v.dataPts.len() - 1).time
+	}
+
+	return s
+}
+
+// Oldest returns the trade time of the oldest data point held by any window, or zero when there is none
+func (v *VWAP) Oldest() time.Time {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	if v.dataPts.len() == 0 {
+		return time.Time{}
+	}
+	return v.dataPts.at(0).time
+}
+
+// Push uses the provided price, volume and trade time to recompute the VWAP
 // When the data points list reaches maxPts, the oldest data point falls off
-// and the new one is added and used in the calculation
-func (v *VWAP) Push(price float64, volume float64) error {
+// and the new one is added and used in the calculation. With a time window,
+// every data point older than t minus the window falls off instead
+// Negative, NaN and infinite prices and volumes are rejected with ErrInvalidPrice and ErrInvalidVolume
+func (v *VWAP) Push(price float64, volume float64, t time.Time) error {
+	return v.PushSide(price, volume, t, SideUnknown)
+}
+
+// PushSide recomputes the VWAP the same way Push does, and also the VWAP of the
+// given aggressor side
+func (v *VWAP) PushSide(price float64, volume float64, t time.Time, side Side) error {
+	if err := validate(price, volume); err != nil {
+		return err
+	}
+
 	v.mux.Lock()
 	defer v.mux.Unlock()
 
-	sumPQ := v.sumPQ + (price * volume)
-	sumQ := v.sumQ + volume
+	return v.push(price, volume, t, side)
+}
+
+// push recomputes the VWAP of every window with a validated data point, and must be called
+// while holding the lock
+func (v *VWAP) push(price float64, volume float64, t time.Time, side Side) error {
+	if side < SideUnknown || side > SideSell {
+		side = SideUnknown
+	}
+
+	// rather than comparing a floating point sum to 0, we count the data points holding a volume,
+	// so the sum of volumes of a resulting window is 0 only when none of them do
+	for _, w := range v.windows {
+		w.nEvict = w.nEvictable(&v.dataPts, t)
+		if w.nQAfterPush(&v.dataPts, volume) == 0 {
+			return ErrZeroVolume
+		}
+	}
+
+	// it is now safe to remove the evicted data points from the windows, and from the list
+	// once no window holds them anymore
+	drop := v.dataPts.len()
+	for _, w := range v.windows {
+		w.evict(&v.dataPts)
+		if w.start < drop {
+			drop = w.start
+		}
+	}
+	v.dataPts.drop(drop)
 
-	// when reaching the max number of processable data points, we recompute
-	// the sums of PQ and Q without the first data point
-	if len(v.dataPts) == v.maxPts {
-		sumPQ = sumPQ - (v.dataPts[0].price * v.dataPts[0].volume)
-		sumQ = sumQ - (v.dataPts[0].volume)
+	// and also safe to add the new data point to the list and the windows
+	pt := newDataPoint(price, volume, t, side)
+	v.dataPts.push(pt)
+	for _, w := range v.windows {
+		w.start -= drop
+		w.add(pt)
+		w.trim(&v.dataPts)
 	}
 
-	// sumPQ should never be 0, but safeguarding just in case
-	if sumPQ == 0 {
-		return divBy0Err
+	v.nPushes++
+	recompute := v.recomputeEvery > 0 && v.nPushes >= v.recomputeEvery
+	if recompute {
+		v.nPushes = 0
 	}
 
-	// it is now safe to remove the first data point from the list
-	if len(v.dataPts) == v.maxPts {
-		v.dataPts = v.dataPts[1:]
+	for _, w := range v.windows {
+		if recompute {
+			w.recompute(&v.dataPts)
+		}
+		w.update()
 	}
 
-	// and also safe to add the new data point to the list and store the sums
-	// and calculate the vwap
-	v.dataPts = append(v.dataPts, newDataPoint(price, volume))
-	v.sumPQ = sumPQ
-	v.sumQ = sumQ
-	v.vwap = sumPQ / sumQ
+	return nil
+}
+
+// validate checks that the price and volume of a trade are finite and non-negative numbers
+func validate(price float64, volume float64) error {
+	if price < 0 || math.IsNaN(price) || math.IsInf(price, 0) {
+		return fmt.Errorf("%w %v", ErrInvalidPrice, price)
+	}
+	if volume < 0 || math.IsNaN(volume) || math.IsInf(volume, 0) {
+		return fmt.Errorf("%w %v", ErrInvalidVolume, volume)
+	}
 
 	return nil
 }
 
 // dataPoint represents a single element of data points used by VWAP
-// to compute the final value
+// to compute the final value. Data points of Decimal only hold their time and exact values
 type dataPoint struct {
 	price  float64
 	volume float64
+	time   time.Time
+	side   Side
+	exact  *decimalPoint
 }
 
-func newDataPoint(price float64, volume float64) dataPoint {
+func newDataPoint(price float64, volume float64, t time.Time, side Side) dataPoint {
 	return dataPoint{
 		price:  price,
 		volume: volume,
+		time:   t,
+		side:   side,
 	}
 }
@@ -1,9 +1,12 @@
 package vwap
 
 import (
+	"errors"
+	"math"
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestNewVWap(t *testing.T) {
@@ -19,12 +22,10 @@ func TestNewVWap(t *testing.T) {
 				maxPts: 0,
 			},
 			want: &VWAP{
-				mux:     &sync.Mutex{},
-				maxPts:  defaultMaxDataPoints,
-				dataPts: []dataPoint{},
-				sumPQ:   0,
-				sumQ:    0,
-				vwap:    0,
+				mux:            &sync.Mutex{},
+				recomputeEvery: defaultRecomputeEvery,
+				dataPts:        newRing(defaultMaxDataPoints),
+				windows:        []*window{{spec: Window{MaxPts: defaultMaxDataPoints}}},
 			},
 		},
 		"it should successfully set maxPts to defaultMaxDataPoints when -5 is provided": {
@@ -32,12 +33,10 @@ func TestNewVWap(t *testing.T) {
 				maxPts: 0,
 			},
 			want: &VWAP{
-				mux:     &sync.Mutex{},
-				maxPts:  defaultMaxDataPoints,
-				dataPts: []dataPoint{},
-				sumPQ:   0,
-				sumQ:    0,
-				vwap:    0,
+				mux:            &sync.Mutex{},
+				recomputeEvery: defaultRecomputeEvery,
+				dataPts:        newRing(defaultMaxDataPoints),
+				windows:        []*window{{spec: Window{MaxPts: defaultMaxDataPoints}}},
 			},
 		},
 		"it should successfully set maxPts to 50": {
@@ -45,12 +44,10 @@ func TestNewVWap(t *testing.T) {
 				maxPts: 50,
 			},
 			want: &VWAP{
-				mux:     &sync.Mutex{},
-				maxPts:  50,
-				dataPts: []dataPoint{},
-				sumPQ:   0,
-				sumQ:    0,
-				vwap:    0,
+				mux:            &sync.Mutex{},
+				recomputeEvery: defaultRecomputeEvery,
+				dataPts:        newRing(50),
+				windows:        []*window{{spec: Window{MaxPts: 50}}},
 			},
 		},
 	}
@@ -95,7 +92,7 @@ func TestVWap_Push(t *testing.T) {
 			},
 			args:       args{5, 0},
 			wantErr:    true,
-			wantErrMsg: divBy0Err.Error(),
+			wantErrMsg: ErrZeroVolume.Error(),
 			wantValues: vwapVars{
 				sumPQ: 0,
 				sumQ:  0,
@@ -103,14 +100,48 @@ func TestVWap_Push(t *testing.T) {
 				nPts:  0,
 			},
 		},
+		"it should return division by 0 error when the only data point with a volume falls off": {
+			fields: fields{
+				maxPts: 1,
+				dataPts: []dataPoint{
+					{price: 5, volume: 2},
+				},
+				sumPQ: 10,
+				sumQ:  2,
+				vwap:  5,
+			},
+			args:       args{3, 0},
+			wantErr:    true,
+			wantErrMsg: ErrZeroVolume.Error(),
+			wantValues: vwapVars{
+				sumPQ: 10,
+				sumQ:  2,
+				vwap:  5,
+				nPts:  1,
+			},
+		},
+		"it should successfully add a data point with a price of 0": {
+			fields: fields{
+				maxPts:  0,
+				dataPts: []dataPoint{},
+			},
+			args:    args{0, 2},
+			wantErr: false,
+			wantValues: vwapVars{
+				sumPQ: 0,
+				sumQ:  2,
+				vwap:  0,
+				nPts:  1,
+			},
+		},
 		"it should successfully add the first data point": {
 			fields: fields{
 				maxPts:  0,
 				dataPts: []dataPoint{},
 			},
-			args:       args{5, 2},
+			args:       args{price: 5, volume: 2},
 			wantErr:    false,
-			wantErrMsg: divBy0Err.Error(),
+			wantErrMsg: ErrZeroVolume.Error(),
 			wantValues: vwapVars{
 				sumPQ: 10,
 				sumQ:  2,
@@ -122,13 +153,13 @@ func TestVWap_Push(t *testing.T) {
 			fields: fields{
 				maxPts: 0,
 				dataPts: []dataPoint{
-					{5, 2},
+					{price: 5, volume: 2},
 				},
 				sumPQ: 10,
 				sumQ:  2,
 				vwap:  5,
 			},
-			args:       args{4, 5},
+			args:       args{price: 4, volume: 5},
 			wantErr:    false,
 			wantErrMsg: "",
 			wantValues: vwapVars{
@@ -142,8 +173,8 @@ func TestVWap_Push(t *testing.T) {
 			fields: fields{
 				maxPts: 2,
 				dataPts: []dataPoint{
-					{5, 2},
-					{4, 5},
+					{price: 5, volume: 2},
+					{price: 4, volume: 5},
 				},
 				sumPQ: 30,
 				sumQ:  7,
@@ -163,12 +194,16 @@ func TestVWap_Push(t *testing.T) {
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			v := New(tt.fields.maxPts)
-			v.dataPts = tt.fields.dataPts
-			v.sumPQ = tt.fields.sumPQ
-			v.sumQ = tt.fields.sumQ
-			v.vwap = tt.fields.vwap
+			w := v.windows[0]
+			for _, pt := range tt.fields.dataPts {
+				v.dataPts.push(pt)
+				w.nQ++
+			}
+			w.sumPQ = neumaier{sum: tt.fields.sumPQ}
+			w.sumQ = neumaier{sum: tt.fields.sumQ}
+			w.vwap = tt.fields.vwap
 
-			if err := v.Push(tt.args.price, tt.args.volume); (err != nil) != tt.wantErr {
+			if err := v.Push(tt.args.price, tt.args.volume, time.Time{}); (err != nil) != tt.wantErr {
 				t.Errorf("Push() error = %v, wantErr %v", err, tt.wantErr)
 
 				if tt.wantErrMsg != err.Error() {
@@ -178,8 +213,8 @@ func TestVWap_Push(t *testing.T) {
 
 			got := vwapVars{
 				vwap:  v.Value(),
-				sumPQ: v.sumPQ,
-				sumQ:  v.sumQ,
+				sumPQ: w.sumPQ.value(),
+				sumQ:  w.sumQ.value(),
 				nPts:  v.NPoints(),
 			}
 			if !reflect.DeepEqual(got, tt.wantValues) {
@@ -188,3 +223,874 @@ func TestVWap_Push(t *testing.T) {
 		})
 	}
 }
+
+func TestNewTimeWindow(t *testing.T) {
+	tests := map[string]struct {
+		window time.Duration
+		want   *VWAP
+	}{
+		"it should fall back to defaultMaxDataPoints when 0 is provided": {
+			window: 0,
+			want: &VWAP{
+				mux:            &sync.Mutex{},
+				recomputeEvery: defaultRecomputeEvery,
+				dataPts:        newRing(defaultMaxDataPoints),
+				windows:        []*window{{spec: Window{MaxPts: defaultMaxDataPoints}}},
+			},
+		},
+		"it should successfully set the window to 5 minutes": {
+			window: 5 * time.Minute,
+			want: &VWAP{
+				mux:            &sync.Mutex{},
+				recomputeEvery: defaultRecomputeEvery,
+				dataPts:        newRing(defaultMaxDataPoints),
+				windows:        []*window{{spec: Window{Duration: 5 * time.Minute}}},
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := NewTimeWindow(tt.window); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewTimeWindow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVWap_Push_time_window(t *testing.T) {
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type push struct {
+		price  float64
+		volume float64
+		time   time.Time
+	}
+	tests := map[string]struct {
+		window   time.Duration
+		pushes   []push
+		wantVWAP float64
+		wantNPts int
+	}{
+		"it should keep every data point within the window": {
+			window: time.Minute,
+			pushes: []push{
+				{5, 2, t0},
+				{4, 5, t0.Add(30 * time.Second)},
+				{3, 1, t0.Add(time.Minute)},
+			},
+			wantVWAP: 33. / 8., // (5*2 + 4*5 + 3*1) / (2 + 5 + 1)
+			wantNPts: 3,
+		},
+		"it should evict every data point older than the window": {
+			window: time.Minute,
+			pushes: []push{
+				{5, 2, t0},
+				{4, 5, t0.Add(10 * time.Second)},
+				{3, 1, t0.Add(65 * time.Second)},
+			},
+			wantVWAP: 23. / 6., // (4*5 + 3*1) / (5 + 1)
+			wantNPts: 2,
+		},
+		"it should evict all previous data points after a long pause": {
+			window: time.Minute,
+			pushes: []push{
+				{5, 2, t0},
+				{4, 5, t0.Add(10 * time.Second)},
+				{3, 1, t0.Add(time.Hour)},
+			},
+			wantVWAP: 3,
+			wantNPts: 1,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			v := NewTimeWindow(tt.window)
+
+			for _, p := range tt.pushes {
+				if err := v.Push(p.price, p.volume, p.time); err != nil {
+					t.Fatalf("Push() unexpected error = %v", err)
+				}
+			}
+
+			if got := v.Value(); got != tt.wantVWAP {
+				t.Errorf("Value() = %v, want %v", got, tt.wantVWAP)
+			}
+			if got := v.NPoints(); got != tt.wantNPts {
+				t.Errorf("NPoints() = %v, want %v", got, tt.wantNPts)
+			}
+		})
+	}
+}
+
+func TestVWap_Push_does_not_allocate(t *testing.T) {
+	v := New(defaultMaxDataPoints)
+	t0 := time.Now()
+
+	// fill the window first, steady state starts when data points fall off
+	for i := 0; i < defaultMaxDataPoints; i++ {
+		_ = v.Push(float64(i+1), 1, t0)
+	}
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		_ = v.Push(10, 2, t0)
+	})
+	if allocs != 0 {
+		t.Errorf("Push() allocs = %v, want 0", allocs)
+	}
+}
+
+func BenchmarkVWAP_Push(b *testing.B) {
+	v := New(defaultMaxDataPoints)
+	t0 := time.Now()
+
+	for i := 0; i < defaultMaxDataPoints; i++ {
+		_ = v.Push(float64(i+1), 1, t0)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = v.Push(float64(i%100+1), 0.5, t0)
+	}
+}
+
+func BenchmarkVWAP_Push_time_window(b *testing.B) {
+	v := NewTimeWindow(time.Second)
+	t0 := time.Now()
+
+	// one trade every millisecond keeps 1000 data points in the window
+	for i := 0; i < 1000; i++ {
+		_ = v.Push(float64(i+1), 1, t0.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = v.Push(float64(i%100+1), 0.5, t0.Add(time.Duration(1000+i)*time.Millisecond))
+	}
+}
+
+func TestVWap_Push_does_not_drift(t *testing.T) {
+	const (
+		nPushes = 2000000
+		maxPts  = 50
+	)
+
+	tests := map[string]struct {
+		opts []Option
+	}{
+		"it should not drift with compensated sums only": {
+			opts: []Option{WithRecomputeEvery(-1)},
+		},
+		"it should not drift with the default recompute cadence": {
+			opts: nil,
+		},
+		"it should not drift when recomputing every 100 pushes": {
+			opts: []Option{WithRecomputeEvery(100)},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			v := New(maxPts, tt.opts...)
+			ref := make([]dataPoint, 0, maxPts+1)
+
+			// sparse huge trades among tiny ones are the worst case for naive float64 sums,
+			// as each huge trade falling off leaves its rounding error behind in the sums
+			for i := 0; i < nPushes; i++ {
+				price, volume := 0.01+float64(i%7)*0.001, 0.0001*float64(i%13+1)
+				if i%97 == 0 {
+					price, volume = 65432.1+float64(i%11), 1234.5+float64(i%17)
+				}
+
+				if err := v.Push(price, volume, time.Time{}); err != nil {
+					t.Fatalf("Push() unexpected error = %v", err)
+				}
+				ref = append(ref, dataPoint{price: price, volume: volume})
+				if len(ref) > maxPts {
+					ref = ref[1:]
+				}
+
+				// check windows holding tiny trades only, where residual errors stand out
+				if i%97 == 96 {
+					want := bruteForceVWAP(ref)
+					if got := v.Value(); math.Abs(got-want) > 1e-12*math.Abs(want) {
+						t.Fatalf("Value() after %d pushes = %v, want %v", i+1, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// bruteForceVWAP computes the VWAP of the given data points from scratch
+func bruteForceVWAP(pts []dataPoint) float64 {
+	sumPQ, sumQ := 0., 0.
+	for _, pt := range pts {
+		sumPQ += pt.price * pt.volume
+		sumQ += pt.volume
+	}
+
+	return sumPQ / sumQ
+}
+
+func TestNewWindows(t *testing.T) {
+	tests := map[string]struct {
+		windows []Window
+		want    *VWAP
+	}{
+		"it should fall back to a window of defaultMaxDataPoints when no window is provided": {
+			windows: nil,
+			want: &VWAP{
+				mux:            &sync.Mutex{},
+				recomputeEvery: defaultRecomputeEvery,
+				dataPts:        newRing(defaultMaxDataPoints),
+				windows:        []*window{{spec: Window{MaxPts: defaultMaxDataPoints}}},
+			},
+		},
+		"it should size the buffer after the largest window of data points": {
+			windows: []Window{
+				{Name: "50", MaxPts: 50},
+				{Name: "1m", Duration: time.Minute, MaxPts: 10},
+				{Name: "500", MaxPts: 500},
+				{Name: "default"},
+			},
+			want: &VWAP{
+				mux:            &sync.Mutex{},
+				recomputeEvery: defaultRecomputeEvery,
+				dataPts:        newRing(500),
+				windows: []*window{
+					{spec: Window{Name: "50", MaxPts: 50}},
+					{spec: Window{Name: "1m", Duration: time.Minute}},
+					{spec: Window{Name: "500", MaxPts: 500}},
+					{spec: Window{Name: "default", MaxPts: defaultMaxDataPoints}},
+				},
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := NewWindows(tt.windows); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewWindows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVWap_Push_windows(t *testing.T) {
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	v := NewWindows([]Window{
+		{Name: "2", MaxPts: 2},
+		{Name: "1m", Duration: time.Minute},
+		{Name: "4", MaxPts: 4},
+	})
+
+	pushes := []dataPoint{
+		{price: 5, volume: 2, time: t0},
+		{price: 4, volume: 5, time: t0.Add(10 * time.Second)},
+		{price: 3, volume: 1, time: t0.Add(20 * time.Second)},
+		{price: 6, volume: 3, time: t0.Add(65 * time.Second)},
+		{price: 2, volume: 4, time: t0.Add(70 * time.Second)},
+	}
+	for _, p := range pushes {
+		if err := v.Push(p.price, p.volume, p.time); err != nil {
+			t.Fatalf("Push() unexpected error = %v", err)
+		}
+	}
+
+	want := []WindowValue{
+		{Name: "2", Value: bruteForceVWAP(pushes[3:]), StdDev: bruteForceStdDev(pushes[3:]), NPoints: 2},
+		{Name: "1m", Value: bruteForceVWAP(pushes[1:]), StdDev: bruteForceStdDev(pushes[1:]), NPoints: 4},
+		{Name: "4", Value: bruteForceVWAP(pushes[1:]), StdDev: bruteForceStdDev(pushes[1:]), NPoints: 4},
+	}
+	got := v.Windows()
+	for i := range want {
+		if got[i].Name != want[i].Name || got[i].NPoints != want[i].NPoints || got[i].Value != want[i].Value ||
+			math.Abs(got[i].StdDev-want[i].StdDev) > 1e-12 {
+			t.Errorf("Windows()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// the first window is the one returned by Value and NPoints
+	if got := v.Value(); got != want[0].Value {
+		t.Errorf("Value() = %v, want %v", got, want[0].Value)
+	}
+	if got := v.NPoints(); got != 2 {
+		t.Errorf("NPoints() = %v, want %v", got, 2)
+	}
+
+	// the buffer only holds the data points of the largest window
+	if got := v.dataPts.len(); got != 4 {
+		t.Errorf("buffered data points = %v, want %v", got, 4)
+	}
+
+	// a long pause empties the time window but not the data points windows
+	if err := v.Push(1, 1, t0.Add(time.Hour)); err != nil {
+		t.Fatalf("Push() unexpected error = %v", err)
+	}
+
+	got = v.Windows()
+	if got[1].NPoints != 1 || got[1].Value != 1 {
+		t.Errorf("Windows()[1] = %v, want a single data point with a value of 1", got[1])
+	}
+	if got[2].NPoints != 4 {
+		t.Errorf("Windows()[2].NPoints = %v, want %v", got[2].NPoints, 4)
+	}
+}
+
+func TestVWap_Push_windows_does_not_allocate(t *testing.T) {
+	v := NewWindows([]Window{{MaxPts: 50}, {MaxPts: 200}, {Duration: time.Second}})
+	t0 := time.Now()
+
+	for i := 0; i < 1000; i++ {
+		_ = v.Push(float64(i+1), 1, t0.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	i := 1000
+	allocs := testing.AllocsPerRun(1000, func() {
+		_ = v.Push(10, 2, t0.Add(time.Duration(i)*time.Millisecond))
+		i++
+	})
+	if allocs != 0 {
+		t.Errorf("Push() allocs = %v, want 0", allocs)
+	}
+}
+
+func TestVWap_StdDev(t *testing.T) {
+	tests := map[string]struct {
+		maxPts     int
+		pushes     []dataPoint
+		wantStdDev float64
+	}{
+		"it should return 0 for a single data point": {
+			maxPts:     3,
+			pushes:     []dataPoint{{price: 5, volume: 2}},
+			wantStdDev: 0,
+		},
+		"it should return 0 for data points with the same price": {
+			maxPts:     3,
+			pushes:     []dataPoint{{price: 5, volume: 2}, {price: 5, volume: 1}, {price: 5, volume: 7}},
+			wantStdDev: 0,
+		},
+		"it should weigh prices by their volume": {
+			maxPts: 3,
+			// vwap = (1*1 + 3*3) / 4 = 2.5, variance = (1*(1-2.5)² + 3*(3-2.5)²) / 4 = 0.75
+			pushes:     []dataPoint{{price: 1, volume: 1}, {price: 3, volume: 3}},
+			wantStdDev: math.Sqrt(0.75),
+		},
+		"it should only use the data points within the window": {
+			maxPts:     2,
+			pushes:     []dataPoint{{price: 100, volume: 50}, {price: 1, volume: 1}, {price: 3, volume: 3}},
+			wantStdDev: math.Sqrt(0.75),
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			v := New(tt.maxPts)
+			for _, p := range tt.pushes {
+				if err := v.Push(p.price, p.volume, p.time); err != nil {
+					t.Fatalf("Push() unexpected error = %v", err)
+				}
+			}
+
+			if got := v.StdDev(); math.Abs(got-tt.wantStdDev) > 1e-12 {
+				t.Errorf("StdDev() = %v, want %v", got, tt.wantStdDev)
+			}
+
+			lower, upper := v.Bands(2)
+			if math.Abs(lower-(v.Value()-2*tt.wantStdDev)) > 1e-12 || math.Abs(upper-(v.Value()+2*tt.wantStdDev)) > 1e-12 {
+				t.Errorf("Bands(2) = %v, %v, want %v, %v", lower, upper, v.Value()-2*tt.wantStdDev, v.Value()+2*tt.wantStdDev)
+			}
+		})
+	}
+}
+
+// bruteForceStdDev computes the volume-weighted standard deviation of the given data points from scratch
+func bruteForceStdDev(pts []dataPoint) float64 {
+	vwap := bruteForceVWAP(pts)
+
+	sumQ, sumDev := 0., 0.
+	for _, pt := range pts {
+		sumDev += pt.volume * (pt.price - vwap) * (pt.price - vwap)
+		sumQ += pt.volume
+	}
+
+	return math.Sqrt(sumDev / sumQ)
+}
+
+func TestVWap_PushSide(t *testing.T) {
+	v := NewWindows([]Window{{Name: "3", MaxPts: 3}, {Name: "5", MaxPts: 5}})
+
+	pushes := []dataPoint{
+		{price: 10, volume: 1, side: SideBuy},
+		{price: 8, volume: 2, side: SideSell},
+		{price: 12, volume: 3, side: SideBuy},
+		{price: 9, volume: 1, side: SideUnknown},
+		{price: 7, volume: 2, side: SideSell},
+	}
+	for _, p := range pushes {
+		if err := v.PushSide(p.price, p.volume, p.time, p.side); err != nil {
+			t.Fatalf("PushSide() unexpected error = %v", err)
+		}
+	}
+
+	// the first window only holds the last 3 data points
+	if got, want := v.Side(SideBuy), (SideValue{Value: 12, Volume: 3}); got != want {
+		t.Errorf("Side(buy) = %v, want %v", got, want)
+	}
+	if got, want := v.Side(SideSell), (SideValue{Value: 7, Volume: 2}); got != want {
+		t.Errorf("Side(sell) = %v, want %v", got, want)
+	}
+
+	// the combined VWAP includes every side
+	if got, want := v.Value(), bruteForceVWAP(pushes[2:]); got != want {
+		t.Errorf("Value() = %v, want %v", got, want)
+	}
+
+	windows := v.Windows()
+	if got, want := windows[1].Buy, (SideValue{Value: 46. / 4., Volume: 4}); got != want {
+		t.Errorf("Windows()[1].Buy = %v, want %v", got, want)
+	}
+	if got, want := windows[1].Sell, (SideValue{Value: 30. / 4., Volume: 4}); got != want {
+		t.Errorf("Windows()[1].Sell = %v, want %v", got, want)
+	}
+
+	// a side without any data point left in the window has no VWAP
+	for i := 0; i < 3; i++ {
+		_ = v.PushSide(11, 1, time.Time{}, SideBuy)
+	}
+	if got := v.Side(SideSell); got != (SideValue{}) {
+		t.Errorf("Side(sell) = %v, want %v", got, SideValue{})
+	}
+}
+
+func TestVWap_Push_validation(t *testing.T) {
+	type pusher interface {
+		Push(price float64, volume float64, t time.Time) error
+		NPoints() int
+	}
+	vwapers := map[string]func() pusher{
+		"VWAP":     func() pusher { return New(10) },
+		"Anchored": func() pusher { return NewAnchored(nil) },
+		"Decayed":  func() pusher { return NewDecayed(time.Minute) },
+		"Decimal":  func() pusher { return NewDecimal(10) },
+	}
+	tests := map[string]struct {
+		price   float64
+		volume  float64
+		wantErr error
+	}{
+		"it should reject a negative price":    {price: -1, volume: 1, wantErr: ErrInvalidPrice},
+		"it should reject a NaN price":         {price: math.NaN(), volume: 1, wantErr: ErrInvalidPrice},
+		"it should reject an infinite price":   {price: math.Inf(1), volume: 1, wantErr: ErrInvalidPrice},
+		"it should reject a negative volume":   {price: 1, volume: -1, wantErr: ErrInvalidVolume},
+		"it should reject a NaN volume":        {price: 1, volume: math.NaN(), wantErr: ErrInvalidVolume},
+		"it should reject an infinite volume":  {price: 1, volume: math.Inf(-1), wantErr: ErrInvalidVolume},
+		"it should reject a zero total volume": {price: 1, volume: 0, wantErr: ErrZeroVolume},
+		"it should accept a valid trade":       {price: 1, volume: 1, wantErr: nil},
+	}
+	for vName, newVWAPer := range vwapers {
+		for name, tt := range tests {
+			t.Run(vName+" "+name, func(t *testing.T) {
+				v := newVWAPer()
+				if err := v.Push(tt.price, tt.volume, time.Time{}); !errors.Is(err, tt.wantErr) {
+					t.Errorf("Push() error = %v, want %v", err, tt.wantErr)
+				}
+
+				wantNPts := 0
+				if tt.wantErr == nil {
+					wantNPts = 1
+				}
+				if got := v.NPoints(); got != wantNPts {
+					t.Errorf("NPoints() = %v, want %v", got, wantNPts)
+				}
+			})
+		}
+	}
+}
+
+func TestVWAP_Snapshot(t *testing.T) {
+	t0 := time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	v := NewWindows([]Window{{MaxPts: 2}, {MaxPts: 10}})
+	if got := v.Snapshot(); !reflect.DeepEqual(got, Snapshot{}) {
+		t.Errorf("Snapshot() = %v, want %v", got, Snapshot{})
+	}
+
+	_ = v.Push(5, 2, t0)
+	_ = v.Push(4, 5, t0.Add(time.Second))
+	_ = v.Push(3, 1, t0.Add(2*time.Second))
+
+	// variance = (4²*5 + 3²*1) / 6 - (23/6)² = 5/36
+	want := Snapshot{
+		Value:   23. / 6.,
+		StdDev:  math.Sqrt(5) / 6,
+		SumPQ:   23,
+		SumQ:    6,
+		NPoints: 2,
+		Oldest:  t0.Add(time.Second),
+		Newest:  t0.Add(2 * time.Second),
+	}
+	got := v.Snapshot()
+	if math.Abs(got.StdDev-want.StdDev) > 1e-12 {
+		t.Errorf("Snapshot().StdDev = %v, want %v", got.StdDev, want.StdDev)
+	}
+	got.StdDev = want.StdDev
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot() = %v, want %v", got, want)
+	}
+}
+
+func TestVWAP_Oldest(t *testing.T) {
+	t0 := time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	v := NewWindows([]Window{{MaxPts: 2}, {Duration: time.Hour}})
+	if got := v.Oldest(); !got.IsZero() {
+		t.Errorf("Oldest() = %v, want zero", got)
+	}
+
+	// the oldest data point is the oldest of the largest window, not of the first one
+	for i := 0; i < 4; i++ {
+		_ = v.Push(1, 1, t0.Add(time.Duration(i)*time.Minute))
+	}
+	if got := v.Oldest(); !got.Equal(t0) {
+		t.Errorf("Oldest() = %v, want %v", got, t0)
+	}
+
+	_ = v.Push(1, 1, t0.Add(time.Hour+2*time.Minute))
+	if got, want := v.Oldest(), t0.Add(2*time.Minute); !got.Equal(want) {
+		t.Errorf("Oldest() = %v, want %v", got, want)
+	}
+}
+
+func TestVWAP_concurrent_reads(t *testing.T) {
+	const nPushes = 20000
+	t0 := time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	v := NewWindows([]Window{{MaxPts: 50}, {Duration: time.Second}}, WithRecomputeEvery(100))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < nPushes; i++ {
+			_ = v.PushSide(float64(i%100+1), 1, t0.Add(time.Duration(i)*time.Millisecond), Side(i%3))
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				// every value of a snapshot belongs to the same state of the VWAP
+				s := v.Snapshot()
+				if s.NPoints > 0 {
+					if math.Abs(s.Value-s.SumPQ/s.SumQ) > 1e-9 {
+						t.Errorf("Snapshot() value %v does not match its sums %v / %v", s.Value, s.SumPQ, s.SumQ)
+						return
+					}
+					if s.NPoints > 50 || s.Newest.Sub(s.Oldest) != time.Duration(s.NPoints-1)*time.Millisecond {
+						t.Errorf("Snapshot() holds %d points from %v to %v", s.NPoints, s.Oldest, s.Newest)
+						return
+					}
+				}
+
+				_, _, _, _ = v.Value(), v.StdDev(), v.NPoints(), v.Side(SideBuy)
+				_, _ = v.Bands(2)
+				_ = v.Windows()
+				_, _ = v.MarshalBinary()
+			}
+		}()
+	}
+
+	wg.Wait()
+	if got := v.NPoints(); got != 50 {
+		t.Errorf("NPoints() = %v, want %v", got, 50)
+	}
+}
+
+func TestVWapers_concurrent_reads(t *testing.T) {
+	type vwaper interface {
+		Push(price float64, volume float64, t time.Time) error
+		Value() float64
+		NPoints() int
+	}
+	vwapers := map[string]vwaper{
+		"Anchored": NewAnchored(Daily(0)),
+		"Decayed":  NewDecayed(time.Minute),
+		"Decimal":  NewDecimal(50),
+	}
+	t0 := time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	for name, v := range vwapers {
+		t.Run(name, func(t *testing.T) {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 2000; i++ {
+					_ = v.Push(float64(i%100+1), 1, t0.Add(time.Duration(i)*time.Millisecond))
+				}
+			}()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					_, _ = v.Value(), v.NPoints()
+				}
+			}
+		})
+	}
+}
+
+func TestVWap_Push_volume_window(t *testing.T) {
+	type push struct {
+		price  float64
+		volume float64
+		side   Side
+	}
+	tests := map[string]struct {
+		volume   float64
+		pushes   []push
+		wantVWAP float64
+		wantSumQ float64
+		wantNPts int
+		wantBuy  SideValue
+		wantSell SideValue
+	}{
+		"it should keep every data point below the target volume": {
+			volume:   10,
+			pushes:   []push{{5, 2, SideBuy}, {4, 5, SideSell}},
+			wantVWAP: 30. / 7.,
+			wantSumQ: 7,
+			wantNPts: 2,
+			wantBuy:  SideValue{Value: 5, Volume: 2},
+			wantSell: SideValue{Value: 4, Volume: 5},
+		},
+		"it should trim the volume of the oldest data point": {
+			volume:   6,
+			pushes:   []push{{5, 2, SideBuy}, {4, 5, SideSell}, {3, 0.5, SideSell}},
+			wantVWAP: (5*0.5 + 4*5 + 3*0.5) / 6,
+			wantSumQ: 6,
+			wantNPts: 3,
+			wantBuy:  SideValue{Value: 5, Volume: 0.5},
+			wantSell: SideValue{Value: (4*5 + 3*0.5) / 5.5, Volume: 5.5},
+		},
+		"it should evict the data points falling out of the target volume": {
+			volume:   6,
+			pushes:   []push{{5, 2, SideBuy}, {4, 5, SideSell}, {3, 2, SideSell}},
+			wantVWAP: (4*4 + 3*2) / 6.,
+			wantSumQ: 6,
+			wantNPts: 2,
+			wantSell: SideValue{Value: (4*4 + 3*2) / 6., Volume: 6},
+		},
+		"it should trim the newest data point holding more than the target volume": {
+			volume:   6,
+			pushes:   []push{{5, 2, SideBuy}, {4, 50, SideSell}},
+			wantVWAP: 4,
+			wantSumQ: 6,
+			wantNPts: 1,
+			wantSell: SideValue{Value: 4, Volume: 6},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			v := NewVolumeWindow(tt.volume)
+			for _, p := range tt.pushes {
+				if err := v.PushSide(p.price, p.volume, time.Time{}, p.side); err != nil {
+					t.Fatalf("PushSide() unexpected error = %v", err)
+				}
+			}
+
+			s := v.Snapshot()
+			if math.Abs(s.Value-tt.wantVWAP) > 1e-12 || math.Abs(s.SumQ-tt.wantSumQ) > 1e-12 {
+				t.Errorf("Value(), sum of volumes = %v, %v, want %v, %v", s.Value, s.SumQ, tt.wantVWAP, tt.wantSumQ)
+			}
+			if s.NPoints != tt.wantNPts {
+				t.Errorf("NPoints() = %v, want %v", s.NPoints, tt.wantNPts)
+			}
+			for side, want := range map[Side]SideValue{SideBuy: tt.wantBuy, SideSell: tt.wantSell} {
+				got := v.Side(side)
+				if math.Abs(got.Value-want.Value) > 1e-12 || math.Abs(got.Volume-want.Volume) > 1e-12 {
+					t.Errorf("Side(%s) = %v, want %v", side, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestVWap_Push_volume_window_matches_brute_force(t *testing.T) {
+	const volume = 25.
+
+	// the sums are recomputed often, so that they are also recomputed with a trimmed data point
+	v := NewWindows([]Window{{Volume: volume}, {MaxPts: 3}}, WithRecomputeEvery(7))
+
+	var pts []dataPoint
+	for i := 0; i < 1000; i++ {
+		pt := dataPoint{price: 100 + math.Sin(float64(i)), volume: float64(i%9) * 0.75}
+		if i == 0 {
+			pt.volume = 1
+		}
+		if err := v.Push(pt.price, pt.volume, time.Time{}); err != nil {
+			t.Fatalf("Push() unexpected error = %v", err)
+		}
+		pts = append(pts, pt)
+
+		// the window holds the newest data points up to the target volume
+		var window []dataPoint
+		sumQ := 0.
+		for j := len(pts) - 1; j >= 0 && sumQ < volume; j-- {
+			pt := pts[j]
+			if sumQ+pt.volume > volume {
+				pt.volume = volume - sumQ
+			}
+			sumQ += pt.volume
+			window = append(window, pt)
+		}
+
+		want := bruteForceVWAP(window)
+		if got := v.Value(); math.Abs(got-want) > 1e-9 {
+			t.Fatalf("push %d: Value() = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestVWap_Percentile(t *testing.T) {
+	tests := map[string]struct {
+		opts       []Option
+		pts        []dataPoint
+		wantMedian float64
+		wantP5     float64
+		wantP95    float64
+	}{
+		"it should return 0 when percentiles are not computed": {
+			pts:        []dataPoint{{price: 1, volume: 1}, {price: 2, volume: 1}},
+			wantMedian: 0,
+			wantP5:     0,
+			wantP95:    0,
+		},
+		"it should weight the prices by their volume": {
+			opts:       []Option{WithPercentiles(5, 95)},
+			pts:        []dataPoint{{price: 3, volume: 1}, {price: 1, volume: 1}, {price: 2, volume: 8}},
+			wantMedian: 2,
+			wantP5:     1,
+			wantP95:    3,
+		},
+		"it should only hold the prices of the window": {
+			opts:       []Option{WithPercentiles(5, 95)},
+			pts:        []dataPoint{{price: 100, volume: 50}, {price: 1, volume: 1}, {price: 2, volume: 1}, {price: 3, volume: 1}},
+			wantMedian: 2,
+			wantP5:     1,
+			wantP95:    3,
+		},
+		"it should ignore the prices without volume": {
+			opts:       []Option{WithPercentiles(5, 95)},
+			pts:        []dataPoint{{price: 2, volume: 1}, {price: 1, volume: 0}, {price: 3, volume: 0}},
+			wantMedian: 2,
+			wantP5:     2,
+			wantP95:    2,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			v := New(3, tt.opts...)
+			for _, pt := range tt.pts {
+				if err := v.Push(pt.price, pt.volume, time.Time{}); err != nil {
+					t.Fatalf("Push() unexpected error = %v", err)
+				}
+			}
+
+			if got := v.Median(); got != tt.wantMedian {
+				t.Errorf("Median() = %v, want %v", got, tt.wantMedian)
+			}
+			if got := v.Percentile(5); got != tt.wantP5 {
+				t.Errorf("Percentile(5) = %v, want %v", got, tt.wantP5)
+			}
+			if got := v.Percentile(95); got != tt.wantP95 {
+				t.Errorf("Percentile(95) = %v, want %v", got, tt.wantP95)
+			}
+		})
+	}
+}
+
+func TestVWap_Windows_percentiles(t *testing.T) {
+	v := NewWindows([]Window{{Name: "2", MaxPts: 2}}, WithPercentiles(95, 50, 5, 101))
+	_ = v.Push(1, 1, time.Time{})
+	_ = v.Push(2, 1, time.Time{})
+	_ = v.Push(3, 3, time.Time{})
+
+	want := []Percentile{{P: 50, Value: 3}, {P: 95, Value: 3}, {P: 5, Value: 2}}
+	if got := v.Windows()[0].Percentiles; !reflect.DeepEqual(got, want) {
+		t.Errorf("Windows() percentiles = %v, want %v", got, want)
+	}
+
+	if got := New(2).Windows()[0].Percentiles; got != nil {
+		t.Errorf("Windows() percentiles = %v, want nil when not computed", got)
+	}
+}
+
+func TestVWap_Percentile_matches_brute_force(t *testing.T) {
+	const volume = 25.
+
+	// the sums are recomputed often, so that the prices are also rebuilt with a trimmed data point
+	v := NewWindows([]Window{{Volume: volume}, {MaxPts: 7}}, WithRecomputeEvery(7), WithPercentiles(5, 95))
+
+	var pts []dataPoint
+	for i := 0; i < 1000; i++ {
+		pt := dataPoint{price: 100 + math.Sin(float64(i)), volume: float64(i%9)*0.75 + 0.1}
+		if err := v.Push(pt.price, pt.volume, time.Time{}); err != nil {
+			t.Fatalf("Push() unexpected error = %v", err)
+		}
+		pts = append(pts, pt)
+
+		var volumeWindow []dataPoint
+		sumQ := 0.
+		for j := len(pts) - 1; j >= 0 && sumQ < volume; j-- {
+			pt := pts[j]
+			if sumQ+pt.volume > volume {
+				pt.volume = volume - sumQ
+			}
+			sumQ += pt.volume
+			volumeWindow = append(volumeWindow, pt)
+		}
+		countWindow := pts
+		if len(countWindow) > 7 {
+			countWindow = countWindow[len(countWindow)-7:]
+		}
+
+		for k, window := range [][]dataPoint{volumeWindow, countWindow} {
+			for _, p := range v.Windows()[k].Percentiles {
+				if want := bruteForcePercentile(window, p.P); p.Value != want {
+					t.Fatalf("push %d: window %d percentile %v = %v, want %v", i, k, p.P, p.Value, want)
+				}
+			}
+		}
+	}
+}
+
+func TestVWap_Push_percentiles_does_not_allocate(t *testing.T) {
+	v := NewWindows([]Window{{MaxPts: defaultMaxDataPoints}, {Volume: 50}}, WithPercentiles(5, 95))
+	t0 := time.Now()
+
+	for i := 0; i < defaultMaxDataPoints; i++ {
+		_ = v.Push(float64(i+1), 1, t0)
+	}
+
+	i := 0
+	allocs := testing.AllocsPerRun(1000, func() {
+		i++
+		_ = v.Push(float64(i%50), 2, t0)
+	})
+	if allocs != 0 {
+		t.Errorf("Push() allocs = %v, want 0", allocs)
+	}
+}
@@ -0,0 +1,290 @@
+package vwap
+
+import (
+	"math"
+	"time"
+)
+
+// Window describes a named window of data points over which a VWAP is computed
+// A window is bounded by the Duration relative to the latest trade time when set,
+// by the last Volume units traded when set, or by the last MaxPts data points otherwise
+// A window bounded by volume trims the volume of its oldest data point, so that it holds
+// exactly the target volume once enough units were traded
+type Window struct {
+	Name     string
+	MaxPts   int
+	Duration time.Duration
+	Volume   float64
+}
+
+// WindowValue is the pre-computed VWAP and volume-weighted standard deviation of a named window,
+// along with the VWAP and volume of the buy and sell aggressors, and the volume-weighted median
+// and percentiles of its prices when computed (see WithPercentiles)
+type WindowValue struct {
+	Name        string
+	Value       float64
+	StdDev      float64
+	NPoints     int
+	Buy         SideValue
+	Sell        SideValue
+	Percentiles []Percentile
+}
+
+// Percentile is the volume-weighted P percentile of the prices of a window, the median being P = 50
+type Percentile struct {
+	P     float64
+	Value float64
+}
+
+// Bands returns the VWAP minus and plus k standard deviations
+func (w WindowValue) Bands(k float64) (lower float64, upper float64) {
+	return bands(w.Value, w.StdDev, k)
+}
+
+// window holds the sums of a Window over the data points buffer shared by all the windows
+// of a VWAP. The window spans from the start index of the buffer to its newest data point
+type window struct {
+	spec   Window
+	start  int
+	nEvict int
+	// trimmed is the volume of the oldest data point of a volume window which was trimmed off
+	trimmed float64
+	nQ      int
+	sumPQ   neumaier
+	sumP2Q  neumaier
+	sumQ    neumaier
+	vwap    float64
+	stdDev  float64
+	sides   [3]sideSums
+	// stats holds the prices of the window when computing percentiles, nil otherwise
+	stats *orderStats
+}
+
+func newWindow(spec Window) *window {
+	if spec.Duration > 0 {
+		spec.MaxPts = 0
+		spec.Volume = 0
+	} else if spec.Volume > 0 {
+		spec.Duration = 0
+		spec.MaxPts = 0
+	} else if spec.MaxPts < 1 {
+		spec.Duration = 0
+		spec.Volume = 0
+		spec.MaxPts = defaultMaxDataPoints
+	}
+
+	return &window{
+		spec: spec,
+	}
+}
+
+// nPoints returns the number of data points of the buffer held by the window
+func (w *window) nPoints(buf *ring) int {
+	return buf.len() - w.start
+}
+
+// nEvictable returns the number of data points, from the oldest of the window, which
+// must fall off before a new data point traded at t can be added
+func (w *window) nEvictable(buf *ring, t time.Time) int {
+	if w.spec.Duration > 0 {
+		horizon := t.Add(-w.spec.Duration)
+
+		n := 0
+		for w.start+n < buf.len() && buf.at(w.start+n).time.Before(horizon) {
+			n++
+		}
+		return n
+	}
+
+	// volume windows are trimmed once the new data point is added (see trim)
+	if w.spec.Volume > 0 {
+		return 0
+	}
+
+	// when reaching the max number of processable data points, the first one falls off
+	if w.nPoints(buf) == w.spec.MaxPts {
+		return 1
+	}
+
+	return 0
+}
+
+// nQAfterPush returns the number of data points holding a volume that the window would hold
+// after evicting its nEvict oldest data points and adding a new one with the given volume
+func (w *window) nQAfterPush(buf *ring, volume float64) int {
+	nQ := w.nQ
+	if volume != 0 {
+		nQ++
+	}
+	for i := 0; i < w.nEvict; i++ {
+		if buf.at(w.start+i).volume != 0 {
+			nQ--
+		}
+	}
+
+	return nQ
+}
+
+// evict removes the nEvict oldest data points of the window from its sums
+func (w *window) evict(buf *ring) {
+	for i := 0; i < w.nEvict; i++ {
+		pt := buf.at(w.start + i)
+		w.sumPQ.add(-(pt.price * pt.volume))
+		w.sumP2Q.add(-(pt.price * pt.price * pt.volume))
+		w.sumQ.add(-pt.volume)
+		if pt.volume != 0 {
+			w.nQ--
+		}
+		w.sides[pt.side].remove(pt)
+		if w.stats != nil {
+			w.stats.subtract(pt.price, pt.volume, true)
+		}
+	}
+
+	w.start += w.nEvict
+	w.nEvict = 0
+}
+
+// add adds the newest data point to the sums of the window
+func (w *window) add(pt dataPoint) {
+	w.sumPQ.add(pt.price * pt.volume)
+	w.sumP2Q.add(pt.price * pt.price * pt.volume)
+	w.sumQ.add(pt.volume)
+	if pt.volume != 0 {
+		w.nQ++
+	}
+	w.sides[pt.side].add(pt)
+	if w.stats != nil {
+		w.stats.add(pt.price, pt.volume)
+	}
+}
+
+// trim evicts the oldest data points of a volume window, and trims the volume of the oldest
+// one it keeps, until the window holds exactly its target volume. The newest data point is
+// never evicted, only trimmed when it holds more than the target volume on its own
+func (w *window) trim(buf *ring) {
+	if w.spec.Volume <= 0 {
+		return
+	}
+
+	for over := w.sumQ.value() - w.spec.Volume; over > 0; {
+		pt := buf.at(w.start)
+		remaining := pt.volume - w.trimmed
+		if remaining > over || w.start == buf.len()-1 {
+			w.subtract(pt, over, false)
+			w.trimmed += over
+			return
+		}
+
+		w.subtract(pt, remaining, true)
+		if pt.volume != 0 {
+			w.nQ--
+			w.sides[pt.side].nQ--
+		}
+		w.start++
+		w.trimmed = 0
+		over -= remaining
+	}
+}
+
+// subtract removes the given volume of a data point from the sums of the window, and from its
+// prices along with the data point itself when evict is set
+func (w *window) subtract(pt dataPoint, volume float64, evict bool) {
+	w.sumPQ.add(-(pt.price * volume))
+	w.sumP2Q.add(-(pt.price * pt.price * volume))
+	w.sumQ.add(-volume)
+	w.sides[pt.side].subtract(pt.price, volume)
+	if w.stats != nil {
+		w.stats.subtract(pt.price, volume, evict)
+	}
+}
+
+// recompute computes the sums of PQ and Q from scratch using the data points held by the window
+func (w *window) recompute(buf *ring) {
+	w.sumPQ = neumaier{}
+	w.sumP2Q = neumaier{}
+	w.sumQ = neumaier{}
+	w.sides = [3]sideSums{}
+	for i := w.start; i < buf.len(); i++ {
+		pt := buf.at(i)
+		if i == w.start {
+			pt.volume -= w.trimmed
+		}
+		w.sumPQ.add(pt.price * pt.volume)
+		w.sumP2Q.add(pt.price * pt.price * pt.volume)
+		w.sumQ.add(pt.volume)
+		w.sides[pt.side].add(pt)
+	}
+
+	w.rebuildStats(buf)
+}
+
+// rebuildStats rebuilds the prices of the window from the data points it holds
+func (w *window) rebuildStats(buf *ring) {
+	if w.stats == nil {
+		return
+	}
+
+	w.stats.reset()
+	for i := w.start; i < buf.len(); i++ {
+		pt := buf.at(i)
+		if i == w.start {
+			pt.volume -= w.trimmed
+		}
+		w.stats.add(pt.price, pt.volume)
+	}
+}
+
+// percentile returns the volume-weighted p percentile of the prices of the window,
+// or 0 when it does not compute them
+func (w *window) percentile(p float64) float64 {
+	if w.stats == nil {
+		return 0
+	}
+	return w.stats.percentile(p)
+}
+
+// update computes the VWAP and the volume-weighted standard deviation from the sums
+func (w *window) update() {
+	w.vwap, w.stdDev = vwapStdDev(w.sumPQ.value(), w.sumP2Q.value(), w.sumQ.value())
+}
+
+// value returns the pre-computed VWAP of the window, along with the given percentiles of its prices
+func (w *window) value(buf *ring, ps []float64) WindowValue {
+	wv := WindowValue{
+		Name:    w.spec.Name,
+		Value:   w.vwap,
+		StdDev:  w.stdDev,
+		NPoints: w.nPoints(buf),
+		Buy:     w.sides[SideBuy].value(),
+		Sell:    w.sides[SideSell].value(),
+	}
+
+	if w.stats != nil && len(ps) > 0 {
+		wv.Percentiles = make([]Percentile, 0, len(ps))
+		for _, p := range ps {
+			wv.Percentiles = append(wv.Percentiles, Percentile{P: p, Value: w.stats.percentile(p)})
+		}
+	}
+
+	return wv
+}
+
+// vwapStdDev computes the VWAP and the volume-weighted standard deviation of prices from
+// the sums of PQ, P²Q and Q, where the variance is sum(P²Q) / sum(Q) - VWAP²
+func vwapStdDev(sumPQ float64, sumP2Q float64, sumQ float64) (float64, float64) {
+	vwap := sumPQ / sumQ
+
+	// rounding errors may turn a variance of 0 into a tiny negative number
+	variance := sumP2Q/sumQ - vwap*vwap
+	if variance < 0 {
+		variance = 0
+	}
+
+	return vwap, math.Sqrt(variance)
+}
+
+// bands returns the value minus and plus k standard deviations
+func bands(value float64, stdDev float64, k float64) (float64, float64) {
+	return value - k*stdDev, value + k*stdDev
+}
@@ -2,19 +2,46 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"go.uber.org/zap"
+	"io"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 	"vwap-service/internal/crypto-streamer/coinbase"
 	"vwap-service/internal/service"
+	"vwap-service/internal/vwap"
 )
 
 const (
 	_envAppEnv         = "ENV"
 	_envOutputPath     = "OUTPUT_PATH"
 	_envTradingPairs   = "TRADING_PAIRS"
+	_envWindow         = "WINDOW"
+	_envDecimal        = "DECIMAL"
+	_envWindows        = "WINDOWS"
+	_envAnchor         = "ANCHOR"
+	_envHalfLife       = "HALF_LIFE"
+	_envIndicators     = "INDICATORS"
+	_envVolumes        = "VOLUMES"
+	_envBarsOutputPath = "BARS_OUTPUT_PATH"
+	_envBarIntervals   = "BAR_INTERVALS"
+	_envPercentiles    = "PERCENTILES"
+	_envOutlierPercent = "OUTLIER_PERCENT"
+	_envOutlierStdDevs = "OUTLIER_STD_DEVS"
+	_envOutlierRef     = "OUTLIER_REFERENCE"
+	_envOutlierMaxRej  = "OUTLIER_MAX_REJECTIONS"
+	_envOutliersPath   = "OUTLIERS_OUTPUT_PATH"
+	_outlierRefLast    = "last"
+	_envHeartbeat      = "HEARTBEAT_TOLERANCE"
+	_envPingInterval   = "PING_INTERVAL"
+	_envPongWait       = "PONG_WAIT"
+	_envWriteTimeout   = "WRITE_TIMEOUT"
+	_envSubscribeAck   = "SUBSCRIBE_TIMEOUT"
+	_anchorManual      = "manual"
 	_defaultOutput     = "/tmp/vwaps.txt"
 	_appEnvDevelopment = "dev"
 )
@@ -23,6 +50,20 @@ type Config struct {
 	dev          bool
 	outputPath   string
 	tradingPairs []string
+	window       time.Duration
+	decimal      bool
+	windows      []vwap.Window
+	anchor       vwap.Schedule
+	halfLife     time.Duration
+	indicators   []string
+	volumes      map[string]float64
+	barsPath     string
+	barIntervals []time.Duration
+	percentiles  []float64
+	outliers     service.OutlierFilter
+	outliersPath string
+	heartbeat    time.Duration
+	clientOpts   []coinbase.Option
 }
 
 func main() {
@@ -38,16 +79,59 @@ func main() {
 	}
 	defer output.Close()
 
+	// bars are only built when they have an output
+	var barOutput io.Writer
+	if config.barsPath != "" {
+		bars, err := os.OpenFile(config.barsPath, os.O_WRONLY|os.O_CREATE, 0777)
+		if err != nil {
+			panic(err)
+		}
+		defer bars.Close()
+		barOutput = bars
+	}
+
+	// outliers are only written when they have an output
+	if config.outliersPath != "" {
+		outliers, err := os.OpenFile(config.outliersPath, os.O_WRONLY|os.O_CREATE, 0777)
+		if err != nil {
+			panic(err)
+		}
+		defer outliers.Close()
+		config.outliers.Output = outliers
+	}
+
 	// prepare new exchange client
-	streamer, err := coinbase.NewClient(ctx, coinbase.WithLogger(logger))
+	clientOpts := append([]coinbase.Option{
+		coinbase.WithLogger(logger),
+		coinbase.WithHeartbeat(config.heartbeat),
+	}, config.clientOpts...)
+	streamer, err := coinbase.NewClient(ctx, clientOpts...)
 	if err != nil {
 		panic(err)
 	}
 	defer streamer.Close()
 
 	// prepare engine
-	engine := service.NewService(ctx, streamer, service.WithLogger(logger), service.WithOutput(output))
-	engine.AddTradingPairs(config.tradingPairs...)
+	engine := service.NewService(ctx, streamer,
+		service.WithLogger(logger),
+		service.WithOutput(output),
+		service.WithWindow(config.window),
+		service.WithDecimal(config.decimal),
+		service.WithWindows(config.windows...),
+		service.WithAnchor(config.anchor),
+		service.WithHalfLife(config.halfLife),
+		service.WithIndicators(config.indicators...),
+		service.WithPercentiles(config.percentiles...),
+		service.WithOutlierFilter(config.outliers),
+		service.WithBars(barOutput, config.barIntervals...),
+	)
+	for _, tp := range config.tradingPairs {
+		if volume, ok := config.volumes[strings.ToUpper(tp)]; ok {
+			engine.AddTradingPair(tp, service.WithPairVolume(volume))
+			continue
+		}
+		engine.AddTradingPairs(tp)
+	}
 
 	// run engine
 	go func() {
@@ -56,7 +140,7 @@ func main() {
 		}
 	}()
 
-	termChan := make(chan os.Signal)
+	termChan := make(chan os.Signal, 1)
 	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM)
 
 	<-termChan
@@ -68,6 +152,20 @@ func initConfig() Config {
 		dev:          isDev(),
 		outputPath:   getOutputPath(),
 		tradingPairs: getTradingPairs(),
+		window:       getWindow(),
+		decimal:      isDecimal(),
+		windows:      getWindows(),
+		anchor:       getAnchor(),
+		halfLife:     getHalfLife(),
+		indicators:   getIndicators(),
+		volumes:      getVolumes(),
+		barsPath:     os.Getenv(_envBarsOutputPath),
+		barIntervals: getBarIntervals(),
+		percentiles:  getPercentiles(),
+		outliers:     getOutlierFilter(),
+		outliersPath: os.Getenv(_envOutliersPath),
+		heartbeat:    getHeartbeat(),
+		clientOpts:   getClientOptions(),
 	}
 }
 
@@ -109,3 +207,201 @@ func getTradingPairs() []string {
 	}
 	return strings.Split(tradingPairs, ",")
 }
+
+func getWindow() time.Duration {
+	d, _ := lookupDuration(_envWindow)
+	return d
+}
+
+func isDecimal() bool {
+	decimal, ok := os.LookupEnv(_envDecimal)
+	return ok && decimal == "true"
+}
+
+// getWindows returns the named windows listed as numbers of trades or durations, e.g. 50,200,1m,1h
+func getWindows() []vwap.Window {
+	windows, ok := os.LookupEnv(_envWindows)
+	if !ok {
+		return nil
+	}
+
+	var specs []vwap.Window
+	for _, name := range strings.Split(windows, ",") {
+		if maxPts, err := strconv.Atoi(name); err == nil {
+			specs = append(specs, vwap.Window{Name: name, MaxPts: maxPts})
+			continue
+		}
+
+		d, err := time.ParseDuration(name)
+		if err != nil {
+			panic(err)
+		}
+		specs = append(specs, vwap.Window{Name: name, Duration: d})
+	}
+
+	return specs
+}
+
+// getAnchor returns the daily schedule starting sessions at the given offset from UTC midnight, e.g. 0h or 13h30m,
+// or the manual schedule
+func getAnchor() vwap.Schedule {
+	anchor, ok := os.LookupEnv(_envAnchor)
+	if !ok {
+		return nil
+	}
+
+	if anchor == _anchorManual {
+		return vwap.Manual()
+	}
+
+	offset, err := time.ParseDuration(anchor)
+	if err != nil {
+		panic(err)
+	}
+
+	return vwap.Daily(offset)
+}
+
+func getHalfLife() time.Duration {
+	d, _ := lookupDuration(_envHalfLife)
+	return d
+}
+
+func getHeartbeat() time.Duration {
+	d, _ := lookupDuration(_envHeartbeat)
+	return d
+}
+
+// getClientOptions returns the keepalive and subscription options of the exchange client which are set,
+// leaving the others to their default
+func getClientOptions() []coinbase.Option {
+	var opts []coinbase.Option
+	if d, ok := lookupDuration(_envPingInterval); ok {
+		opts = append(opts, coinbase.WithPingInterval(d))
+	}
+	if d, ok := lookupDuration(_envPongWait); ok {
+		opts = append(opts, coinbase.WithPongWait(d))
+	}
+	if d, ok := lookupDuration(_envWriteTimeout); ok {
+		opts = append(opts, coinbase.WithWriteTimeout(d))
+	}
+	if d, ok := lookupDuration(_envSubscribeAck); ok {
+		opts = append(opts, coinbase.WithSubscribeTimeout(d))
+	}
+	return opts
+}
+
+func lookupDuration(env string) (time.Duration, bool) {
+	value, ok := os.LookupEnv(env)
+	if !ok {
+		return 0, false
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		panic(err)
+	}
+
+	return d, true
+}
+
+func getIndicators() []string {
+	indicators, ok := os.LookupEnv(_envIndicators)
+	if !ok || indicators == "" {
+		return nil
+	}
+	return strings.Split(indicators, ",")
+}
+
+// getVolumes returns the volume windows of trading pairs listed as pairs and volumes, e.g. BTC-USD:10,ETH-USD:100
+func getVolumes() map[string]float64 {
+	volumes, ok := os.LookupEnv(_envVolumes)
+	if !ok || volumes == "" {
+		return nil
+	}
+
+	pairVolumes := make(map[string]float64)
+	for _, pairVolume := range strings.Split(volumes, ",") {
+		i := strings.LastIndex(pairVolume, ":")
+		if i < 0 {
+			panic(fmt.Sprintf("invalid volume window '%s'", pairVolume))
+		}
+
+		volume, err := strconv.ParseFloat(pairVolume[i+1:], 64)
+		if err != nil {
+			panic(err)
+		}
+		pairVolumes[strings.ToUpper(pairVolume[:i])] = volume
+	}
+
+	return pairVolumes
+}
+
+func getBarIntervals() []time.Duration {
+	intervals, ok := os.LookupEnv(_envBarIntervals)
+	if !ok || intervals == "" {
+		return nil
+	}
+
+	var ds []time.Duration
+	for _, interval := range strings.Split(intervals, ",") {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			panic(err)
+		}
+		ds = append(ds, d)
+	}
+
+	return ds
+}
+
+func getPercentiles() []float64 {
+	percentiles, ok := os.LookupEnv(_envPercentiles)
+	if !ok || percentiles == "" {
+		return nil
+	}
+
+	var ps []float64
+	for _, percentile := range strings.Split(percentiles, ",") {
+		p, err := strconv.ParseFloat(percentile, 64)
+		if err != nil {
+			panic(err)
+		}
+		ps = append(ps, p)
+	}
+
+	return ps
+}
+
+func getOutlierFilter() service.OutlierFilter {
+	filter := service.OutlierFilter{}
+	if os.Getenv(_envOutlierRef) == _outlierRefLast {
+		filter.Reference = service.ReferenceLast
+	}
+
+	if percent := os.Getenv(_envOutlierPercent); percent != "" {
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil {
+			panic(err)
+		}
+		filter.MaxPercent = p
+	}
+
+	if stdDevs := os.Getenv(_envOutlierStdDevs); stdDevs != "" {
+		k, err := strconv.ParseFloat(stdDevs, 64)
+		if err != nil {
+			panic(err)
+		}
+		filter.MaxStdDevs = k
+	}
+
+	if maxRejections := os.Getenv(_envOutlierMaxRej); maxRejections != "" {
+		n, err := strconv.Atoi(maxRejections)
+		if err != nil {
+			panic(err)
+		}
+		filter.MaxRejections = n
+	}
+
+	return filter
+}